@@ -0,0 +1,81 @@
+// Package sqlbuilder assembles Redshift statements from keywords, quoted identifiers
+// and escaped literals, so names and values supplied in Terraform configuration can
+// never change the shape of a statement.
+package sqlbuilder
+
+import (
+	"strings"
+)
+
+// QuoteIdentifier quotes name as a Redshift identifier. Embedded double quotes are
+// doubled and anything after a NUL byte is dropped, since it cannot be sent to the server.
+//
+// https://docs.aws.amazon.com/redshift/latest/dg/r_names.html
+func QuoteIdentifier(name string) string {
+	if end := strings.IndexRune(name, 0); end > -1 {
+		name = name[:end]
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// QuoteLiteral quotes value as a Redshift string literal. Redshift treats backslashes in
+// literals as escape characters, so they are escaped along with single quotes.
+func QuoteLiteral(value string) string {
+	if end := strings.IndexRune(value, 0); end > -1 {
+		value = value[:end]
+	}
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `''`, -1)
+	return "'" + value + "'"
+}
+
+// Builder accumulates the parts of a statement, separated by single spaces
+type Builder struct {
+	parts []string
+}
+
+// New starts a statement with the given keywords
+func New(keywords ...string) *Builder {
+	return (&Builder{}).Keyword(keywords...)
+}
+
+// Keyword appends SQL keywords verbatim. It must only be given trusted text.
+func (b *Builder) Keyword(keywords ...string) *Builder {
+	for _, keyword := range keywords {
+		if keyword != "" {
+			b.parts = append(b.parts, keyword)
+		}
+	}
+	return b
+}
+
+// Ident appends a comma separated list of quoted identifiers
+func (b *Builder) Ident(names ...string) *Builder {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = QuoteIdentifier(name)
+	}
+	b.parts = append(b.parts, strings.Join(quoted, ", "))
+	return b
+}
+
+// QualifiedIdent appends a dotted name such as schema.table, quoting each part
+func (b *Builder) QualifiedIdent(parts ...string) *Builder {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = QuoteIdentifier(part)
+	}
+	b.parts = append(b.parts, strings.Join(quoted, "."))
+	return b
+}
+
+// Literal appends an escaped string literal
+func (b *Builder) Literal(value string) *Builder {
+	b.parts = append(b.parts, QuoteLiteral(value))
+	return b
+}
+
+// String returns the statement
+func (b *Builder) String() string {
+	return strings.Join(b.parts, " ")
+}
@@ -0,0 +1,73 @@
+package sqlbuilder
+
+import (
+	"testing"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	cases := map[string]string{
+		"alice":           `"alice"`,
+		"MixedCase":       `"MixedCase"`,
+		"with-hyphen":     `"with-hyphen"`,
+		"IAM:alice":       `"IAM:alice"`,
+		`quo"te`:          `"quo""te"`,
+		"trunc\x00ated":   `"trunc"`,
+		`x"; DROP USER y`: `"x""; DROP USER y"`,
+	}
+
+	for input, expected := range cases {
+		if actual := QuoteIdentifier(input); actual != expected {
+			t.Errorf("QuoteIdentifier(%q): expected %s, got %s", input, expected, actual)
+		}
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	cases := map[string]string{
+		"Testpass123":      `'Testpass123'`,
+		"it's":             `'it''s'`,
+		`back\slash`:       `'back\\slash'`,
+		`\'; DROP USER x;`: `'\\''; DROP USER x;'`,
+		"trunc\x00ated":    `'trunc'`,
+	}
+
+	for input, expected := range cases {
+		if actual := QuoteLiteral(input); actual != expected {
+			t.Errorf("QuoteLiteral(%q): expected %s, got %s", input, expected, actual)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	cases := []struct {
+		builder  *Builder
+		expected string
+	}{
+		{
+			New("CREATE USER").Ident("IAM:alice").Keyword("PASSWORD").Literal("it's").Keyword("CREATEDB"),
+			`CREATE USER "IAM:alice" PASSWORD 'it''s' CREATEDB`,
+		},
+		{
+			New("GRANT", "SELECT,INSERT", "ON ALL TABLES IN SCHEMA").Ident("Sales-Data").Keyword("TO GROUP").Ident("analysts"),
+			`GRANT SELECT,INSERT ON ALL TABLES IN SCHEMA "Sales-Data" TO GROUP "analysts"`,
+		},
+		{
+			New("ALTER GROUP").Ident("analysts").Keyword("ADD USER").Ident("alice", "Bob"),
+			`ALTER GROUP "analysts" ADD USER "alice", "Bob"`,
+		},
+		{
+			New("ALTER TABLE").QualifiedIdent("public", "Orders").Keyword("OWNER TO").Ident("admin"),
+			`ALTER TABLE "public"."Orders" OWNER TO "admin"`,
+		},
+		{
+			New("DROP SCHEMA", "").Ident("old"),
+			`DROP SCHEMA "old"`,
+		},
+	}
+
+	for _, c := range cases {
+		if actual := c.builder.String(); actual != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, actual)
+		}
+	}
+}
@@ -2,14 +2,13 @@ package main
 
 import (
 	"github.com/frankfarrell/terraform-provider-redshift/redshift"
-	"github.com/hashicorp/terraform/plugin"
-	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
 )
 
 func main() {
+	defer redshift.CloseConnections()
+
 	plugin.Serve(&plugin.ServeOpts{
-		ProviderFunc: func() terraform.ResourceProvider {
-			return redshift.Provider()
-		},
+		ProviderFunc: redshift.Provider,
 	})
 }
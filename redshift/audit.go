@@ -0,0 +1,224 @@
+package redshift
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Every statement the provider runs goes through auditConn, which logs it with secrets redacted
+// and, when audit_log_path is set, appends a JSON record of it to the audit log.
+
+const redacted = "[REDACTED]"
+
+var (
+	// PASSWORD 'secret', including doubled quotes inside the literal
+	passwordLiteralPattern = regexp.MustCompile(`(?i)(\bPASSWORD\s+)'(?:[^']|'')*'`)
+	// md5 followed by the hex digest of password + username
+	md5HashPattern = regexp.MustCompile(`(?i)\bmd5[0-9a-f]{32}\b`)
+	// sha256|digest or sha256|salt|digest
+	sha256HashPattern = regexp.MustCompile(`(?i)\bsha256\|[^'\s]*`)
+)
+
+// redactStatement removes password literals and password hashes from a statement
+func redactStatement(query string) string {
+	query = passwordLiteralPattern.ReplaceAllString(query, "${1}'"+redacted+"'")
+	query = md5HashPattern.ReplaceAllString(query, "md5"+redacted)
+	return sha256HashPattern.ReplaceAllString(query, "sha256|"+redacted)
+}
+
+// auditRecord is one line of the audit log
+type auditRecord struct {
+	Time       string  `json:"time"`
+	Database   string  `json:"database"`
+	User       string  `json:"user"`
+	Operation  string  `json:"operation,omitempty"`
+	Resource   string  `json:"resource,omitempty"`
+	ID         string  `json:"id,omitempty"`
+	Statement  string  `json:"statement"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// auditLog appends JSON records to a file. Statements run on many connections in parallel,
+// so writes go through the mutex.
+type auditLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open audit log: %s", err)
+	}
+	return &auditLog{file: file}, nil
+}
+
+func (a *auditLog) write(record *auditRecord) {
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("[WARN] Could not encode audit record: %s", err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("[WARN] Could not write audit log: %s", err)
+	}
+}
+
+func (a *auditLog) Close() error {
+	return a.file.Close()
+}
+
+// auditTarget is what the provider was working on when it ran a statement, carried on the
+// operation context
+type auditTarget struct {
+	operation string
+	resource  string
+	id        string
+}
+
+type auditTargetKey struct{}
+
+func withAuditTarget(ctx context.Context, target auditTarget) context.Context {
+	return context.WithValue(ctx, auditTargetKey{}, target)
+}
+
+// auditConn wraps a driver connection, recording each statement and transaction boundary
+type auditConn struct {
+	conn     driver.Conn
+	audit    *auditLog
+	database string
+	user     string
+}
+
+func (c *auditConn) record(ctx context.Context, query string, started time.Time, err error) {
+
+	record := &auditRecord{
+		Time:       started.UTC().Format(time.RFC3339Nano),
+		Database:   c.database,
+		User:       c.user,
+		Statement:  redactStatement(query),
+		DurationMs: float64(time.Since(started)) / float64(time.Millisecond),
+	}
+	if target, ok := ctx.Value(auditTargetKey{}).(auditTarget); ok {
+		record.Operation = target.operation
+		record.Resource = target.resource
+		record.ID = target.id
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	log.Printf("[DEBUG] %s %s on %s (%.1fms): %s", record.Operation, record.Resource, record.Database, record.DurationMs, record.Statement)
+
+	if c.audit != nil {
+		c.audit.write(record)
+	}
+}
+
+func (c *auditConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(query)
+}
+
+func (c *auditConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *auditConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *auditConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+
+	started := time.Now()
+
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if beginner, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.conn.Begin()
+	}
+
+	c.record(ctx, "BEGIN", started, err)
+	if err != nil {
+		return nil, err
+	}
+	return &auditTx{tx: tx, conn: c, ctx: ctx}, nil
+}
+
+func (c *auditConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+
+	if plan := dryRunPlanFrom(ctx); plan != nil {
+		plan.add(query)
+		return driver.RowsAffected(0), nil
+	}
+
+	execer, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	started := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.record(ctx, query, started, err)
+	}
+	return result, err
+}
+
+func (c *auditConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+
+	queryer, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	started := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.record(ctx, query, started, err)
+	}
+	return rows, err
+}
+
+func (c *auditConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+type auditTx struct {
+	tx   driver.Tx
+	conn *auditConn
+	ctx  context.Context
+}
+
+func (t *auditTx) Commit() error {
+	started := time.Now()
+	err := t.tx.Commit()
+	t.conn.record(t.ctx, "COMMIT", started, err)
+	return err
+}
+
+func (t *auditTx) Rollback() error {
+	started := time.Now()
+	err := t.tx.Rollback()
+	t.conn.record(t.ctx, "ROLLBACK", started, err)
+	return err
+}
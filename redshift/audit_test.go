@@ -0,0 +1,141 @@
+package redshift
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactStatement(t *testing.T) {
+	cases := []struct {
+		statement string
+		expected  string
+	}{
+		{
+			`CREATE USER "bob" PASSWORD 'Secret123'`,
+			`CREATE USER "bob" PASSWORD '[REDACTED]'`,
+		},
+		{
+			`ALTER USER "bob" password 'it''s \\ secret' VALID UNTIL '2030-01-01'`,
+			`ALTER USER "bob" password '[REDACTED]' VALID UNTIL '2030-01-01'`,
+		},
+		{
+			`CREATE USER "bob" PASSWORD 'md53f84a3c26198d9b94054ca7a3839366d'`,
+			`CREATE USER "bob" PASSWORD '[REDACTED]'`,
+		},
+		{
+			`SELECT passwd = 'md53f84a3c26198d9b94054ca7a3839366d' FROM pg_shadow`,
+			`SELECT passwd = 'md5[REDACTED]' FROM pg_shadow`,
+		},
+		{
+			`SELECT 'sha256|Mypassword1|salt' AS hash`,
+			`SELECT 'sha256|[REDACTED]' AS hash`,
+		},
+		{
+			`ALTER USER "bob" PASSWORD DISABLE`,
+			`ALTER USER "bob" PASSWORD DISABLE`,
+		},
+		{
+			`SELECT usename FROM pg_user_info WHERE usesysid = $1`,
+			`SELECT usename FROM pg_user_info WHERE usesysid = $1`,
+		},
+	}
+
+	for _, c := range cases {
+		if actual := redactStatement(c.statement); actual != c.expected {
+			t.Errorf("redactStatement(%q):\nexpected %q\ngot      %q", c.statement, c.expected, actual)
+		}
+	}
+}
+
+type auditedConnector struct {
+	connector driver.Connector
+	audit     *auditLog
+}
+
+func (c *auditedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &auditConn{conn: conn, audit: c.audit, database: "dev", user: "admin"}, nil
+}
+
+func (c *auditedConnector) Driver() driver.Driver {
+	return nil
+}
+
+func TestAuditLogRecordsStatements(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	client := (&Config{}).Client()
+	db := sql.OpenDB(&auditedConnector{connector: &fakeConnector{}, audit: audit})
+	defer db.Close()
+
+	ctx := withAuditTarget(context.Background(), auditTarget{operation: "create", resource: "user bob", id: "100"})
+
+	err = client.withTransaction(ctx, db, "user bob", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `CREATE USER "bob" PASSWORD 'Secret123'`)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := db.ExecContext(ctx, "fail"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	audit.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer file.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid audit record %q: %s", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+
+	var statements []string
+	for _, record := range records {
+		statements = append(statements, record.Statement)
+	}
+	expected := []string{"BEGIN", `CREATE USER "bob" PASSWORD '[REDACTED]'`, "COMMIT", "fail"}
+	if strings.Join(statements, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected statements %q, got %q", expected, statements)
+	}
+
+	create := records[1]
+	if create.Database != "dev" || create.User != "admin" || create.Operation != "create" || create.Resource != "user bob" || create.ID != "100" || create.Time == "" {
+		t.Errorf("unexpected record %#v", create)
+	}
+	if records[3].Error != "syntax error" {
+		t.Errorf("expected the failure to be recorded, got %#v", records[3])
+	}
+}
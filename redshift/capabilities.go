@@ -0,0 +1,178 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Features that differ between provisioned clusters, Serverless, node types and releases
+const (
+	featureRoles              = "roles"
+	featureRowLevelSecurity   = "row level security"
+	featureDynamicDataMasking = "dynamic data masking"
+	featureDatashares         = "datashares"
+	featureCrossDatabase      = "cross-database queries"
+)
+
+// featureViews are the system views that only exist on clusters supporting each feature.
+// Looking for the views is more reliable than comparing build numbers, which differ between
+// tracks and regions.
+var featureViews = map[string]string{
+	featureRoles:              "svv_roles",
+	featureRowLevelSecurity:   "svv_rls_policy",
+	featureDynamicDataMasking: "svv_masking_policy",
+	featureDatashares:         "svv_datashares",
+	featureCrossDatabase:      "svv_redshift_databases",
+}
+
+// serverlessView only exists on Redshift Serverless
+const serverlessView = "sys_serverless_usage"
+
+// eg PostgreSQL 8.0.2 on i686-pc-linux-gnu, compiled by GCC gcc (GCC) 3.4.2 20041017 (Red Hat 3.4.2-6.fc3), Redshift 1.0.12103
+var redshiftVersionPattern = regexp.MustCompile(`Redshift (\d+)\.(\d+)\.(\d+)`)
+
+// capabilities is what the cluster was found to support when the provider was configured
+type capabilities struct {
+	// version is the output of version()
+	version string
+
+	// build is the Redshift build number, eg 12103 for Redshift 1.0.12103. 0 if it could not be
+	// parsed
+	build int
+
+	serverless bool
+	features   map[string]bool
+
+	// superuser is set when the provider connects as a superuser, who can read password hashes
+	superuser bool
+}
+
+// detectCapabilities reads the version of the cluster and which feature views it has
+func detectCapabilities(ctx context.Context, q Queryer) (*capabilities, error) {
+
+	c := &capabilities{features: make(map[string]bool)}
+
+	if err := q.QueryRowContext(ctx, "SELECT version()").Scan(&c.version); err != nil {
+		return nil, err
+	}
+	if match := redshiftVersionPattern.FindStringSubmatch(c.version); match != nil {
+		c.build, _ = strconv.Atoi(match[3])
+	}
+
+	err := q.QueryRowContext(ctx, "SELECT usesuper FROM pg_user WHERE usename = current_user").Scan(&c.superuser)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	views := []string{serverlessView}
+	for _, view := range featureViews {
+		views = append(views, view)
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = 'pg_catalog' AND c.relname IN ('`+strings.Join(views, "', '")+`')`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var view string
+		if err := rows.Scan(&view); err != nil {
+			return nil, err
+		}
+		found[view] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.serverless = found[serverlessView]
+	for feature, view := range featureViews {
+		c.features[feature] = found[view]
+	}
+
+	return c, nil
+}
+
+func (c *capabilities) String() string {
+
+	flavour := "Redshift"
+	if c.serverless {
+		flavour = "Redshift Serverless"
+	}
+
+	var supported []string
+	for feature, ok := range c.features {
+		if ok {
+			supported = append(supported, feature)
+		}
+	}
+	sort.Strings(supported)
+	if len(supported) == 0 {
+		supported = []string{"none of the optional features"}
+	}
+
+	if c.build == 0 {
+		return fmt.Sprintf("%s (%s), supporting %s", flavour, c.version, strings.Join(supported, ", "))
+	}
+	return fmt.Sprintf("%s build %d, supporting %s", flavour, c.build, strings.Join(supported, ", "))
+}
+
+// detectCapabilities connects to the default database, failing when the cluster cannot be
+// reached, and stores what it supports on the client
+func (c *Client) detectCapabilities(ctx context.Context) error {
+
+	db, err := c.getConnection(c.config.database)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(withAuditTarget(ctx, auditTarget{operation: "configure"}), defaultOperationTimeout)
+	defer cancel()
+
+	capabilities, err := detectCapabilities(ctx, db)
+	if err != nil {
+		return fmt.Errorf("Could not connect to %s: %s", c.config.url, err)
+	}
+
+	log.Printf("[INFO] Connected to %s", capabilities)
+	c.capabilitiesMutex.Lock()
+	c.capabilities = capabilities
+	c.capabilitiesMutex.Unlock()
+	return nil
+}
+
+// clusterCapabilities returns what the cluster supports. Without a default database they are not
+// detected when the provider is configured, but through db, a pool of the first database a
+// resource uses. It returns nil, supporting none of the optional features, when they can not be
+// detected.
+func (c *Client) clusterCapabilities(ctx context.Context, db Queryer) *capabilities {
+
+	c.capabilitiesMutex.Lock()
+	defer c.capabilitiesMutex.Unlock()
+
+	if c.capabilities != nil {
+		return c.capabilities
+	}
+
+	capabilities, err := detectCapabilities(ctx, db)
+	if err != nil {
+		log.Printf("[WARN] Could not detect the capabilities of %s: %s", c.config.url, err)
+		return nil
+	}
+
+	log.Printf("[INFO] Connected to %s", capabilities)
+	c.capabilities = capabilities
+	return capabilities
+}
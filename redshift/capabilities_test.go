@@ -0,0 +1,71 @@
+package redshift
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func catalogViews(views ...string) cannedResult {
+	result := cannedResult{match: "pg_namespace", columns: []string{"relname"}}
+	for _, view := range views {
+		result.rows = append(result.rows, []driver.Value{view})
+	}
+	return result
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	client := cannedClient(
+		cannedResult{
+			match:   "version()",
+			columns: []string{"version"},
+			rows:    [][]driver.Value{{"PostgreSQL 8.0.2 on i686-pc-linux-gnu, compiled by GCC gcc (GCC) 3.4.2 20041017 (Red Hat 3.4.2-6.fc3), Redshift 1.0.12103"}},
+		},
+		catalogViews("svv_datashares", "svv_redshift_databases"),
+	)
+	defer client.Close()
+
+	if err := client.detectCapabilities(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	capabilities := client.capabilities
+	if capabilities.build != 12103 || capabilities.serverless {
+		t.Errorf("expected a provisioned cluster on build 12103, got %s", capabilities)
+	}
+	if !capabilities.features[featureDatashares] || !capabilities.features[featureCrossDatabase] || capabilities.features[featureRoles] {
+		t.Errorf("expected datashares and cross-database queries without roles, got %s", capabilities)
+	}
+	if description := capabilities.String(); description != "Redshift build 12103, supporting cross-database queries, datashares" {
+		t.Errorf("unexpected description %s", description)
+	}
+}
+
+func TestDetectCapabilitiesOnServerless(t *testing.T) {
+	client := cannedClient(
+		cannedResult{
+			match:   "version()",
+			columns: []string{"version"},
+			rows:    [][]driver.Value{{"PostgreSQL 8.0.2 on i686-pc-linux-gnu, Redshift 1.0.48805"}},
+		},
+		catalogViews("sys_serverless_usage", "svv_roles"),
+	)
+	defer client.Close()
+
+	if err := client.detectCapabilities(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !client.capabilities.serverless || !client.capabilities.features[featureRoles] {
+		t.Errorf("expected Serverless with roles, got %s", client.capabilities)
+	}
+}
+
+func TestDetectCapabilitiesReportsUnreachableCluster(t *testing.T) {
+	client := cannedClient()
+	defer client.Close()
+
+	// version() returns no rows
+	if err := client.detectCapabilities(context.Background()); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -1,57 +1,339 @@
 package redshift
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	_ "github.com/lib/pq"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/lib/pq"
 )
 
-// Config holds API and APP keys to authenticate to Datadog.
+// Config holds the connection settings for the Redshift cluster.
 type Config struct {
-	url      string
-	user     string
-	password string
-	port     string
-	sslmode  string
+	url             string
+	user            string
+	password        string
+	port            string
+	sslmode         string
+	database        string
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	maxRetries      int
+
+	// passwordDriftDetection compares user passwords with the hashes stored by the cluster,
+	// which only superusers can read
+	passwordDriftDetection bool
+
+	// connectTimeout bounds opening a connection and statementTimeout every statement, zero
+	// for no limit. Sessions are labelled with applicationName and routed to the WLM queue
+	// of queryGroup.
+	connectTimeout   time.Duration
+	statementTimeout time.Duration
+	applicationName  string
+	queryGroup       string
+
+	// dryRun collects the statements of Create, Update and Delete instead of executing them,
+	// appending them to dryRunPath when it is set
+	dryRun     bool
+	dryRunPath string
+
+	// tlsFiles are the certificates and key connections are secured with, nil for the defaults
+	tlsFiles *tlsFiles
+
+	// dialer opens the network connections to the cluster, nil to dial directly
+	dialer pq.Dialer
+
+	// audit records every statement run on the cluster, nil when no audit log is configured
+	audit *auditLog
+
+	// credentials is consulted every time a connection is opened, so temporary credentials
+	// are refreshed before the pools reconnect
+	credentials credentialsProvider
 }
 
+// Client manages one connection pool per database. Terraform walks the graph in parallel,
+// so every access to the pools goes through the mutex.
 type Client struct {
-	config        Config
-	getConnection func(database string) (*sql.DB, error)
+	config      Config
+	mutex       sync.Mutex
+	connections map[string]*sql.DB
+
+	// dryRunPlans holds the plan of each resource being applied in dry run mode
+	dryRunMutex sync.Mutex
+	dryRunPlans map[*schema.ResourceData]*dryRunPlan
+
+	// capabilities is what the cluster supports, detected when the provider is configured or,
+	// without a default database, when a resource first needs them
+	capabilitiesMutex sync.Mutex
+	capabilities      *capabilities
+
+	// sleep waits between retries until the operation is cancelled, replaced in tests
+	sleep func(context.Context, time.Duration) error
 }
 
+var (
+	clientsMutex sync.Mutex
+	clients      []*Client
+)
+
 // New redshift client
 func (c *Config) Client() *Client {
 
-	connections := make(map[string]*sql.DB)
-
-	getConnection := func(database string) (*sql.DB, error) {
-		if connections[database] == nil {
-			conninfo := fmt.Sprintf("sslmode=%v user=%v password=%v host=%v port=%v dbname=%v",
-				c.sslmode,
-				c.user,
-				c.password,
-				c.url,
-				c.port,
-				database)
-
-			db, err := sql.Open("postgres", conninfo)
-			if err != nil {
-				db.Close()
-				return nil, err
+	client := &Client{
+		config:      *c,
+		connections: make(map[string]*sql.DB),
+		dryRunPlans: make(map[*schema.ResourceData]*dryRunPlan),
+		sleep:       sleepContext,
+	}
+
+	clientsMutex.Lock()
+	clients = append(clients, client)
+	clientsMutex.Unlock()
+
+	return client
+}
+
+// detectsPasswordDrift reports whether reads compare user passwords with the stored hashes. db is
+// where the capabilities are detected if they are not known yet.
+func (c *Client) detectsPasswordDrift(ctx context.Context, db Queryer) bool {
+	if !c.config.passwordDriftDetection {
+		return false
+	}
+	capabilities := c.clusterCapabilities(ctx, db)
+	return capabilities != nil && capabilities.superuser
+}
+
+// getConnection returns the pool for database, opening it on first use
+func (c *Client) getConnection(database string) (*sql.DB, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if db, ok := c.connections[database]; ok {
+		return db, nil
+	}
+
+	db := sql.OpenDB(&connector{config: &c.config, database: database})
+
+	db.SetMaxOpenConns(c.config.maxOpenConns)
+	db.SetMaxIdleConns(c.config.maxIdleConns)
+	db.SetConnMaxLifetime(c.config.connMaxLifetime)
+
+	c.connections[database] = db
+
+	return db, nil
+}
+
+// closeConnection closes and forgets the pool for database, if one is open, so its idle sessions
+// do not keep the database from being dropped or renamed
+func (c *Client) closeConnection(database string) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	db, ok := c.connections[database]
+	if !ok {
+		return nil
+	}
+	delete(c.connections, database)
+	return db.Close()
+}
+
+// resourceDatabase returns the database named by attribute on the resource, falling back to the
+// provider database when the resource omits it
+func (c *Client) resourceDatabase(d *schema.ResourceData, attribute string) string {
+
+	if v, ok := d.GetOk(attribute); ok {
+		return v.(string)
+	}
+	return c.config.database
+}
+
+// recordResourceDatabase sets attribute to the database the resource is managed in, so the
+// state keeps pointing at it when the provider database changes later
+func (c *Client) recordResourceDatabase(d *schema.ResourceData, attribute string) {
+	d.Set(attribute, c.resourceDatabase(d, attribute))
+}
+
+// getResourceConnection returns the pool for the database named by attribute on the resource,
+// falling back to the provider database when the resource omits it
+func (c *Client) getResourceConnection(d *schema.ResourceData, attribute string) (*sql.DB, error) {
+
+	database := c.resourceDatabase(d, attribute)
+
+	if database == "" {
+		return nil, fmt.Errorf("%s must be set on the resource when no database is configured on the provider", attribute)
+	}
+
+	return c.getConnection(database)
+}
+
+// connector opens connections with the credentials that are current at connect time
+type connector struct {
+	config   *Config
+	database string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+
+	user, password, err := c.config.credentials.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conninfo := c.conninfo(user, password)
+
+	var conn driver.Conn
+	if c.config.dialer != nil {
+		conn, err = pq.DialOpen(c.config.dialer, conninfo)
+	} else {
+		var pqConnector *pq.Connector
+		pqConnector, err = pq.NewConnector(conninfo)
+		if err == nil {
+			conn, err = pqConnector.Connect(ctx)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	audited := &auditConn{conn: conn, audit: c.config.audit, database: c.database, user: user}
+
+	// The session has to be set up even in dry run, reads run on it
+	sessionCtx := withDryRunPlan(withAuditTarget(ctx, auditTarget{operation: "connect"}), nil)
+	for _, statement := range c.config.sessionStatements() {
+		if _, err := audited.ExecContext(sessionCtx, statement, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Could not set up session: %s", err)
+		}
+	}
+
+	return audited, nil
+}
+
+// sessionStatements returns the statements run on every new connection
+func (c *Config) sessionStatements() []string {
+
+	var statements []string
+	if c.statementTimeout > 0 {
+		statements = append(statements, fmt.Sprintf("SET statement_timeout TO %d", c.statementTimeout/time.Millisecond))
+	}
+	if c.queryGroup != "" {
+		statements = append(statements, "SET query_group TO "+sqlbuilder.QuoteLiteral(c.queryGroup))
+	}
+	return statements
+}
+
+// conninfo returns the libpq key/value connection string for user and password
+func (c *connector) conninfo(user string, password string) string {
+
+	conninfo := fmt.Sprintf("sslmode=%v user=%v password=%v host=%v port=%v dbname=%v",
+		quoteConninfoValue(c.config.sslmode),
+		quoteConninfoValue(user),
+		quoteConninfoValue(password),
+		quoteConninfoValue(c.config.url),
+		quoteConninfoValue(c.config.port),
+		quoteConninfoValue(c.database))
+
+	if c.config.connectTimeout > 0 {
+		conninfo += fmt.Sprintf(" connect_timeout=%d", c.config.connectTimeout/time.Second)
+	}
+	if c.config.applicationName != "" {
+		conninfo += " application_name=" + quoteConninfoValue(c.config.applicationName)
+	}
+
+	if files := c.config.tlsFiles; files != nil {
+		if files.sslrootcert != "" {
+			conninfo += " sslrootcert=" + quoteConninfoValue(files.sslrootcert)
+		}
+		if files.sslcert != "" {
+			conninfo += " sslcert=" + quoteConninfoValue(files.sslcert) + " sslkey=" + quoteConninfoValue(files.sslkey)
+		}
+	}
+
+	return conninfo
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// quoteConninfoValue quotes a value for a libpq key/value connection string
+func quoteConninfoValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
+
+// Close closes every pool opened by the client, the ssh tunnel and the audit log, and removes
+// TLS files written for inline certificates. It returns the first error encountered.
+func (c *Client) Close() error {
+
+	clientsMutex.Lock()
+	for i, client := range clients {
+		if client == c {
+			clients = append(clients[:i], clients[i+1:]...)
+			break
+		}
+	}
+	clientsMutex.Unlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var firstErr error
+
+	for database, db := range c.connections {
+		if err := db.Close(); err != nil {
+			log.Printf("Could not close connection to database %s: %s", database, err)
+			if firstErr == nil {
+				firstErr = err
 			}
-			return db, nil
-		} else {
-			return connections[database], nil
 		}
+		delete(c.connections, database)
+	}
+
+	if closer, ok := c.config.dialer.(io.Closer); ok {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if err := c.config.tlsFiles.Close(); err != nil && firstErr == nil {
+		firstErr = err
 	}
+	c.config.tlsFiles = nil
 
-	client := Client{
-		config:        *c,
-		getConnection: getConnection,
+	if c.config.audit != nil {
+		if err := c.config.audit.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		c.config.audit = nil
 	}
 
-	return &client
+	return firstErr
 }
 
-//When do we close the connection?
+// CloseConnections closes the pools of every client created by this plugin process.
+// It is called when the plugin shuts down.
+func CloseConnections() {
+
+	clientsMutex.Lock()
+	closing := clients
+	clients = nil
+	clientsMutex.Unlock()
+
+	// Close takes clientsMutex to remove the client, so it is not held here
+	for _, client := range closing {
+		client.Close()
+	}
+}
@@ -0,0 +1,237 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestClientGetConnectionCachesPerDatabase(t *testing.T) {
+	config := Config{url: "localhost", port: "5439", sslmode: "disable", maxOpenConns: 5, maxIdleConns: 2}
+	client := config.Client()
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	results := make([]*sql.DB, 20)
+
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db, err := client.getConnection("dev")
+			if err != nil {
+				t.Errorf("err: %s", err)
+			}
+			results[i] = db
+		}(i)
+	}
+	wg.Wait()
+
+	for _, db := range results {
+		if db != results[0] {
+			t.Fatal("expected every call for the same database to share one pool")
+		}
+	}
+
+	other, err := client.getConnection("other")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if other == results[0] {
+		t.Fatal("expected a separate pool per database")
+	}
+
+	if stats := other.Stats(); stats.MaxOpenConnections != 5 {
+		t.Fatalf("expected max open connections 5, got %d", stats.MaxOpenConnections)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	config := Config{url: "localhost", port: "5439", sslmode: "disable"}
+	client := config.Client()
+
+	db, err := client.getConnection("dev")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	reopened, err := client.getConnection("dev")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if reopened == db {
+		t.Fatal("expected a new pool after Close")
+	}
+	client.Close()
+}
+
+func TestDropDatabaseClosesItsPool(t *testing.T) {
+	client, connector := cannedClientConnector()
+	defer client.Close()
+
+	analytics, err := client.getConnection("analytics")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	d := schema.TestResourceDataRaw(t, redshiftDatabase().Schema, map[string]interface{}{"database_name": "analytics", "host_database_name": "dev"})
+	d.SetId("200")
+
+	if diags := resourceRedshiftDatabaseDelete(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("unexpected diagnostics %#v", diags)
+	}
+
+	if _, ok := client.connections["analytics"]; ok {
+		t.Error("expected the pool of the dropped database to be forgotten")
+	}
+	if err := analytics.Ping(); err == nil || !strings.Contains(err.Error(), "database is closed") {
+		t.Errorf("expected the pool of the dropped database to be closed, got %v", err)
+	}
+	if strings.Join(connector.executed, "\n") != `DROP DATABASE "analytics"` {
+		t.Errorf("unexpected statements %q", connector.executed)
+	}
+
+	if err := client.closeConnection("unknown"); err != nil {
+		t.Errorf("err: %s", err)
+	}
+}
+
+func TestClientCloseForgetsClient(t *testing.T) {
+	registered := func(client *Client) bool {
+		clientsMutex.Lock()
+		defer clientsMutex.Unlock()
+		for _, c := range clients {
+			if c == client {
+				return true
+			}
+		}
+		return false
+	}
+
+	client := (&Config{url: "localhost", port: "5439", sslmode: "disable"}).Client()
+	other := (&Config{url: "localhost", port: "5439", sslmode: "disable"}).Client()
+	defer other.Close()
+
+	if !registered(client) || !registered(other) {
+		t.Fatal("expected the clients to be closed when the plugin shuts down")
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if registered(client) || !registered(other) {
+		t.Error("expected only the closed client to be forgotten")
+	}
+
+	CloseConnections()
+	if registered(other) {
+		t.Error("expected CloseConnections to forget every client")
+	}
+}
+
+func TestClientGetResourceConnectionFallsBackToProviderDatabase(t *testing.T) {
+	config := Config{url: "localhost", port: "5439", sslmode: "disable", database: "dev"}
+	client := config.Client()
+	defer client.Close()
+
+	providerDb, err := client.getConnection("dev")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	d := schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{"username": "alice"})
+	db, err := client.getResourceConnection(d, "database")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if db != providerDb {
+		t.Fatal("expected the provider database to be used when the resource omits it")
+	}
+
+	d = schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{"username": "alice", "database": "other"})
+	db, err = client.getResourceConnection(d, "database")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if db == providerDb {
+		t.Fatal("expected the resource database to override the provider database")
+	}
+
+	noDefault := (&Config{url: "localhost"}).Client()
+	defer noDefault.Close()
+	d = schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{"username": "alice"})
+	if _, err := noDefault.getResourceConnection(d, "database"); err == nil {
+		t.Fatal("expected an error when neither the resource nor the provider set a database")
+	}
+}
+
+func TestRecordResourceDatabase(t *testing.T) {
+	client := (&Config{database: "dev"}).Client()
+	defer client.Close()
+
+	d := schema.TestResourceDataRaw(t, redshiftSchema().Schema, map[string]interface{}{"schema_name": "analytics"})
+	client.recordResourceDatabase(d, "database")
+	if database := d.Get("database").(string); database != "dev" {
+		t.Errorf("expected the provider database to be recorded, got %q", database)
+	}
+
+	// A recorded database is kept when the provider database changes
+	client.config.database = "other"
+	client.recordResourceDatabase(d, "database")
+	if database := d.Get("database").(string); database != "dev" {
+		t.Errorf("expected the recorded database to be kept, got %q", database)
+	}
+
+	for _, resource := range []*schema.Resource{redshiftSchema(), redshiftSchemaGroupPrivilege(), redshiftSchemaDefaultUserGroupPrivilege()} {
+		if attribute := resource.Schema["database"]; !attribute.Optional || !attribute.Computed || !attribute.ForceNew {
+			t.Errorf("expected database to be optional, computed and force new, got %#v", attribute)
+		}
+	}
+}
+
+func TestConnectorConninfo(t *testing.T) {
+	config := &Config{
+		url:             "cluster.example.com",
+		port:            "5439",
+		sslmode:         "require",
+		connectTimeout:  10 * time.Second,
+		applicationName: "terraform 'ci'",
+	}
+
+	conninfo := (&connector{config: config, database: "dev"}).conninfo("terraform", "secret")
+
+	for _, expected := range []string{"host='cluster.example.com'", "dbname='dev'", "connect_timeout=10", `application_name='terraform \'ci\''`} {
+		if !strings.Contains(conninfo, expected) {
+			t.Errorf("expected %q in %q", expected, conninfo)
+		}
+	}
+
+	config.connectTimeout = 0
+	config.applicationName = ""
+	conninfo = (&connector{config: config, database: "dev"}).conninfo("terraform", "secret")
+	if strings.Contains(conninfo, "connect_timeout") || strings.Contains(conninfo, "application_name") {
+		t.Errorf("expected no connect_timeout or application_name in %q", conninfo)
+	}
+}
+
+func TestSessionStatements(t *testing.T) {
+	config := &Config{statementTimeout: 90 * time.Second, queryGroup: "terraform's"}
+
+	expected := []string{"SET statement_timeout TO 90000", "SET query_group TO 'terraform''s'"}
+	if actual := config.sessionStatements(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	if actual := (&Config{}).sessionStatements(); len(actual) != 0 {
+		t.Errorf("expected no session statements by default, got %q", actual)
+	}
+}
@@ -0,0 +1,166 @@
+package redshift
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+// https://docs.aws.amazon.com/redshift/latest/mgmt/jdbc20-build-connection-url.html
+// https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING
+
+// connectionURLSchemes are the prefixes that make url a connection URL rather than a host
+var connectionURLSchemes = []string{"jdbc:redshift://", "jdbc:postgresql://", "postgresql://", "postgres://", "redshift://"}
+
+// connectionURL holds what the url attribute specifies. Everything but host is empty when url
+// is a bare host.
+type connectionURL struct {
+	host     string
+	port     string
+	database string
+	sslmode  string
+
+	// sslOnly is set when the URL only asks for an encrypted connection, with the JDBC ssl=true,
+	// so a stricter sslmode is not a conflict
+	sslOnly bool
+}
+
+// parseConnectionURL parses a JDBC or postgres connection URL, or a bare host
+func parseConnectionURL(value string) (*connectionURL, error) {
+
+	isURL := false
+	for _, scheme := range connectionURLSchemes {
+		if strings.HasPrefix(strings.ToLower(value), scheme) {
+			isURL = true
+			break
+		}
+	}
+	if !isURL {
+		if strings.Contains(value, "://") || strings.HasPrefix(value, "jdbc:") {
+			return nil, fmt.Errorf("Unsupported url %q, expected a host or a jdbc:redshift://, jdbc:postgresql:// or postgres:// URL", value)
+		}
+		return &connectionURL{host: value}, nil
+	}
+
+	u, err := url.Parse(strings.TrimPrefix(value, "jdbc:"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse url: %s", err)
+	}
+
+	// Credentials in the URL would end up in plan output, url is not sensitive
+	if u.User != nil {
+		return nil, fmt.Errorf("url must not contain credentials, set user and password instead")
+	}
+
+	c := &connectionURL{
+		host:     u.Hostname(),
+		port:     u.Port(),
+		database: strings.TrimPrefix(u.Path, "/"),
+	}
+	if c.host == "" {
+		return nil, fmt.Errorf("url %q does not contain a host", value)
+	}
+	if strings.Contains(c.database, "/") {
+		return nil, fmt.Errorf("url %q has more than a database in its path", value)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse the options of url: %s", err)
+	}
+
+	hasSSL := false
+	for name, values := range query {
+		value := values[len(values)-1]
+		switch strings.ToLower(name) {
+		case "sslmode":
+			valid := false
+			for _, mode := range sslModes {
+				valid = valid || value == mode
+			}
+			if !valid {
+				return nil, fmt.Errorf("Unsupported sslmode %q in url, expected one of %s", value, strings.Join(sslModes, ", "))
+			}
+			c.sslmode = value
+		case "ssl":
+			hasSSL = true
+			switch strings.ToLower(value) {
+			case "true", "1":
+				c.sslOnly = true
+			case "false", "0":
+			default:
+				return nil, fmt.Errorf("Unsupported ssl %q in url, expected true or false", value)
+			}
+		case "user", "password", "uid", "pwd":
+			return nil, fmt.Errorf("url must not contain credentials, set user and password instead")
+		default:
+			log.Printf("[WARN] Ignoring unsupported option %s in url", name)
+		}
+	}
+
+	// sslmode is more specific than ssl
+	if hasSSL && c.sslmode == "" {
+		if c.sslOnly {
+			c.sslmode = "require"
+		} else {
+			c.sslmode = "disable"
+		}
+	} else {
+		c.sslOnly = false
+	}
+
+	return c, nil
+}
+
+// resolveSetting returns the value of attribute, which can be set explicitly, including from its
+// environment variable, in the URL, or neither and defaults to fallback. The explicit value takes
+// precedence over the URL, with a warning when they disagree.
+func resolveSetting(attribute string, explicit string, fromURL string, fallback string) (string, diag.Diagnostics) {
+
+	switch {
+	case explicit == "" && fromURL == "":
+		return fallback, nil
+	case explicit == "":
+		return fromURL, nil
+	case fromURL != "" && fromURL != explicit:
+		return explicit, diag.Diagnostics{{
+			Severity:      diag.Warning,
+			Summary:       fmt.Sprintf("%s %q takes precedence over the url", attribute, explicit),
+			Detail:        fmt.Sprintf("The url sets %s to %q, which is ignored.", attribute, fromURL),
+			AttributePath: cty.GetAttrPath(attribute),
+		}}
+	}
+	return explicit, nil
+}
+
+// sslModeStrength orders sslModes from the least to the most secure
+func sslModeStrength(mode string) int {
+	for i, m := range sslModes {
+		if m == mode {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveSSLMode is resolveSetting for sslmode. An explicit sslmode can only make the connection
+// more secure than the URL asks for, a stricter one refining ssl=true without a warning.
+func (c *connectionURL) resolveSSLMode(explicit string) (string, diag.Diagnostics) {
+
+	if explicit != "" && c.sslmode != "" && sslModeStrength(explicit) < sslModeStrength(c.sslmode) {
+		return "", diag.Diagnostics{{
+			Severity:      diag.Error,
+			Summary:       fmt.Sprintf("sslmode %q is weaker than the url", explicit),
+			Detail:        fmt.Sprintf("The url asks for sslmode %q. Change the url to connect with a weaker sslmode.", c.sslmode),
+			AttributePath: cty.GetAttrPath("sslmode"),
+		}}
+	}
+	if c.sslOnly && explicit != "" {
+		return explicit, nil
+	}
+	return resolveSetting("sslmode", explicit, c.sslmode, "require")
+}
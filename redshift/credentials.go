@@ -0,0 +1,175 @@
+package redshift
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftserverless"
+)
+
+// https://docs.aws.amazon.com/redshift/latest/mgmt/generating-user-credentials.html
+// https://docs.aws.amazon.com/redshift/latest/APIReference/API_GetClusterCredentials.html
+// https://docs.aws.amazon.com/redshift-serverless/latest/APIReference/API_GetCredentials.html
+
+// Temporary credentials are refreshed this long before they expire, so a connection
+// opened just before expiry still has time to authenticate
+const credentialsExpiryMargin = 1 * time.Minute
+
+// credentialsProvider supplies the user and password used whenever a new connection is opened.
+// ctx is the context the connection is opened under.
+type credentialsProvider interface {
+	credentials(ctx context.Context) (user string, password string, err error)
+}
+
+type staticCredentials struct {
+	user     string
+	password string
+}
+
+func (c *staticCredentials) credentials(ctx context.Context) (string, string, error) {
+	return c.user, c.password, nil
+}
+
+// temporaryCredentials obtains temporary database credentials for an IAM identity with fetch and
+// caches them until they are about to expire
+type temporaryCredentials struct {
+	mutex sync.Mutex
+	fetch func(ctx context.Context) (user string, password string, expiration time.Time, err error)
+	now   func() time.Time
+
+	user       string
+	password   string
+	expiration time.Time
+}
+
+func (c *temporaryCredentials) credentials(ctx context.Context) (string, string, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.password != "" && c.now().Add(credentialsExpiryMargin).Before(c.expiration) {
+		return c.user, c.password, nil
+	}
+
+	user, password, expiration, err := c.fetch(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	c.user, c.password, c.expiration = user, password, expiration
+
+	log.Printf("Temporary credentials for %s expire at %s", c.user, c.expiration)
+
+	return c.user, c.password, nil
+}
+
+type clusterCredentialsConfig struct {
+	clusterIdentifier string
+	workgroupName     string
+	dbUser            string
+	autoCreate        bool
+	dbGroups          []string
+	durationSeconds   int
+	region            string
+	profile           string
+	endpoint          string
+}
+
+// awsConfig loads the AWS configuration the credentials are requested with, from the
+// environment and the shared config files
+func (c clusterCredentialsConfig) awsConfig(ctx context.Context) (aws.Config, error) {
+
+	var options []func(*awsconfig.LoadOptions) error
+	if c.region != "" {
+		options = append(options, awsconfig.WithRegion(c.region))
+	}
+	if c.profile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(c.profile))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("Could not load the AWS configuration for temporary credentials: %s", err)
+	}
+	return cfg, nil
+}
+
+// baseEndpoint returns endpoint for the BaseEndpoint of a service client, nil for the default
+func (c clusterCredentialsConfig) baseEndpoint() *string {
+	if c.endpoint == "" {
+		return nil
+	}
+	return aws.String(c.endpoint)
+}
+
+// newClusterCredentials returns the credentials of a provisioned cluster, from GetClusterCredentials
+func newClusterCredentials(ctx context.Context, c clusterCredentialsConfig) (*temporaryCredentials, error) {
+
+	cfg, err := c.awsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &redshift.GetClusterCredentialsInput{
+		ClusterIdentifier: aws.String(c.clusterIdentifier),
+		DbUser:            aws.String(c.dbUser),
+		AutoCreate:        aws.Bool(c.autoCreate),
+		DurationSeconds:   aws.Int32(int32(c.durationSeconds)),
+		DbGroups:          c.dbGroups,
+	}
+
+	svc := redshift.NewFromConfig(cfg, func(o *redshift.Options) {
+		o.BaseEndpoint = c.baseEndpoint()
+	})
+
+	return &temporaryCredentials{
+		fetch: func(ctx context.Context) (string, string, time.Time, error) {
+			log.Printf("Requesting temporary credentials for %s on cluster %s", c.dbUser, c.clusterIdentifier)
+
+			output, err := svc.GetClusterCredentials(ctx, input)
+			if err != nil {
+				return "", "", time.Time{}, fmt.Errorf("Could not get temporary cluster credentials: %s", err)
+			}
+			return aws.ToString(output.DbUser), aws.ToString(output.DbPassword), aws.ToTime(output.Expiration), nil
+		},
+		now: time.Now,
+	}, nil
+}
+
+// newWorkgroupCredentials returns the credentials of a Redshift Serverless workgroup, from
+// GetCredentials. The db user is derived from the IAM identity, so dbUser, autoCreate and
+// dbGroups do not apply.
+func newWorkgroupCredentials(ctx context.Context, c clusterCredentialsConfig) (*temporaryCredentials, error) {
+
+	cfg, err := c.awsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &redshiftserverless.GetCredentialsInput{
+		WorkgroupName:   aws.String(c.workgroupName),
+		DurationSeconds: aws.Int32(int32(c.durationSeconds)),
+	}
+
+	svc := redshiftserverless.NewFromConfig(cfg, func(o *redshiftserverless.Options) {
+		o.BaseEndpoint = c.baseEndpoint()
+	})
+
+	return &temporaryCredentials{
+		fetch: func(ctx context.Context) (string, string, time.Time, error) {
+			log.Printf("Requesting temporary credentials on workgroup %s", c.workgroupName)
+
+			output, err := svc.GetCredentials(ctx, input)
+			if err != nil {
+				return "", "", time.Time{}, fmt.Errorf("Could not get temporary workgroup credentials: %s", err)
+			}
+			return aws.ToString(output.DbUser), aws.ToString(output.DbPassword), aws.ToTime(output.Expiration), nil
+		},
+		now: time.Now,
+	}, nil
+}
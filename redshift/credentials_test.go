@@ -0,0 +1,131 @@
+package redshift
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClusterCredentialsRefreshesBeforeExpiry(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	expiration := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		r.ParseForm()
+		if action := r.Form.Get("Action"); action != "GetClusterCredentials" {
+			t.Errorf("unexpected action %q", action)
+		}
+		if cluster := r.Form.Get("ClusterIdentifier"); cluster != "examplecluster" {
+			t.Errorf("unexpected cluster identifier %q", cluster)
+		}
+		if user := r.Form.Get("DbUser"); user != "ci" {
+			t.Errorf("unexpected db user %q", user)
+		}
+		fmt.Fprintf(w, `<GetClusterCredentialsResponse xmlns="http://redshift.amazonaws.com/doc/2012-12-01/">
+  <GetClusterCredentialsResult>
+    <DbUser>IAM:ci</DbUser>
+    <DbPassword>password%d</DbPassword>
+    <Expiration>%s</Expiration>
+  </GetClusterCredentialsResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</GetClusterCredentialsResponse>`, requests, expiration.Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	credentials, err := newClusterCredentials(context.Background(), clusterCredentialsConfig{
+		clusterIdentifier: "examplecluster",
+		dbUser:            "ci",
+		durationSeconds:   900,
+		region:            "us-east-1",
+		endpoint:          server.URL,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	now := expiration.Add(-10 * time.Minute)
+	credentials.now = func() time.Time { return now }
+
+	user, password, err := credentials.credentials(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if user != "IAM:ci" || password != "password1" {
+		t.Fatalf("unexpected credentials %s/%s", user, password)
+	}
+
+	if _, password, _ = credentials.credentials(context.Background()); password != "password1" || requests != 1 {
+		t.Fatalf("expected cached credentials, got %s after %d requests", password, requests)
+	}
+
+	now = expiration.Add(-30 * time.Second)
+
+	if _, password, _ = credentials.credentials(context.Background()); password != "password2" || requests != 2 {
+		t.Fatalf("expected refreshed credentials, got %s after %d requests", password, requests)
+	}
+}
+
+func TestWorkgroupCredentials(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	expiration := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "RedshiftServerless.GetCredentials" {
+			t.Errorf("unexpected target %q", target)
+		}
+		if authorization := r.Header.Get("Authorization"); !strings.Contains(authorization, "/redshift-serverless/aws4_request") {
+			t.Errorf("expected the request to be signed for redshift-serverless, got %q", authorization)
+		}
+		var input map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&input)
+		if input["workgroupName"] != "default" || input["durationSeconds"] != float64(900) {
+			t.Errorf("unexpected input %v", input)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprintf(w, `{"dbUser": "IAMR:ci", "dbPassword": "password1", "expiration": %d}`, expiration.Unix())
+	}))
+	defer server.Close()
+
+	credentials, err := newWorkgroupCredentials(context.Background(), clusterCredentialsConfig{
+		workgroupName:   "default",
+		dbUser:          "ci",
+		durationSeconds: 900,
+		region:          "us-east-1",
+		endpoint:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	user, password, err := credentials.credentials(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if user != "IAMR:ci" || password != "password1" || !credentials.expiration.Equal(expiration) {
+		t.Errorf("unexpected credentials %s/%s expiring at %s", user, password, credentials.expiration)
+	}
+
+	// A cancelled operation does not wait for the service
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	credentials.password = ""
+	if _, _, err := credentials.credentials(cancelled); err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
@@ -1,20 +1,22 @@
 package redshift
 
 import (
-	"log"
+	"context"
 	"strconv"
 
-	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func dataSourceRedshiftSchema() *schema.Resource {
 	return &schema.Resource{
-		Read: dataSourceRedshiftSchemaReadByName,
+		ReadContext: dataSourceRedshiftSchemaReadByName,
 
 		Schema: map[string]*schema.Schema{
 			"database": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Database the schema belongs to. Defaults to the provider database",
 			},
 			"schema_name": {
 				Type:     schema.TypeString,
@@ -29,29 +31,30 @@ func dataSourceRedshiftSchema() *schema.Resource {
 	}
 }
 
-func dataSourceRedshiftSchemaReadByName(d *schema.ResourceData, meta interface{}) error {
+func dataSourceRedshiftSchemaReadByName(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	var (
 		oid   int
 		owner int
 	)
 
 	name := d.Get("schema_name").(string)
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	err := redshiftClient.QueryRow("select oid, nspowner from pg_namespace where nspname = $1", name).Scan(&oid, &owner)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutRead, "schema "+name)
+	defer cancel()
+
+	err := redshiftClient.QueryRowContext(ctx, "select oid, nspowner from pg_namespace where nspname = $1", name).Scan(&oid, &owner)
 
 	if err != nil {
-		log.Print(err)
-		return err
+		return diagnostics(wrapError("read", "schema "+name, err))
 	}
 
 	d.SetId(strconv.Itoa(oid))
 	d.Set("owner", owner)
 
-	return err
+	return diagnostics(err)
 }
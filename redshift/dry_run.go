@@ -0,0 +1,178 @@
+package redshift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// With dry_run set, Create, Update and Delete still run their queries but every statement that
+// would change the cluster is collected into a dryRunPlan instead of being executed. The plan is
+// then returned as the error diagnostic of the operation, so Terraform leaves the state untouched.
+
+// errDryRunStopped is returned by steps that cannot go on without the statements having run,
+// such as waiting for a created object to appear in the catalog
+var errDryRunStopped = errors.New("stopped because statements are not executed in dry run mode")
+
+type dryRunPlan struct {
+	mutex      sync.Mutex
+	resource   string
+	operation  string
+	statements []string
+
+	// stopped is set once a step gave up because the statements were not executed
+	stopped bool
+}
+
+func (p *dryRunPlan) add(statement string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.statements = append(p.statements, redactStatement(statement))
+}
+
+// stop records that the operation could not go on without the statements having run
+func (p *dryRunPlan) stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopped = true
+}
+
+// render formats the plan as a SQL script
+func (p *dryRunPlan) render() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	script := fmt.Sprintf("-- %s %s\n", p.operation, p.resource)
+	if len(p.statements) == 0 {
+		script += "-- no statements\n"
+	}
+	for _, statement := range p.statements {
+		script += strings.TrimSpace(statement) + ";\n"
+	}
+	return script
+}
+
+// DryRunError is what every Create, Update and Delete fails with in dry run mode, reported as
+// an error diagnostic. Script holds the statements that would have been run.
+type DryRunError struct {
+	Script string
+}
+
+func (e *DryRunError) Error() string {
+	return "Dry run, nothing was changed. The following SQL would be run:\n" + e.Script
+}
+
+type dryRunPlanKey struct{}
+
+func withDryRunPlan(ctx context.Context, plan *dryRunPlan) context.Context {
+	return context.WithValue(ctx, dryRunPlanKey{}, plan)
+}
+
+func dryRunPlanFrom(ctx context.Context) *dryRunPlan {
+	plan, _ := ctx.Value(dryRunPlanKey{}).(*dryRunPlan)
+	return plan
+}
+
+// dryRunPlanFor returns the plan collecting statements for d, nil when not in dry run mode
+func (c *Client) dryRunPlanFor(d *schema.ResourceData) *dryRunPlan {
+	c.dryRunMutex.Lock()
+	defer c.dryRunMutex.Unlock()
+	return c.dryRunPlans[d]
+}
+
+// dryRun runs operation with a plan collecting its statements and returns the plan as an error,
+// unless the operation failed for another reason
+func (c *Client) dryRun(d *schema.ResourceData, operation string, f func() diag.Diagnostics) diag.Diagnostics {
+
+	plan := &dryRunPlan{operation: operation}
+
+	c.dryRunMutex.Lock()
+	c.dryRunPlans[d] = plan
+	c.dryRunMutex.Unlock()
+
+	defer func() {
+		c.dryRunMutex.Lock()
+		delete(c.dryRunPlans, d)
+		c.dryRunMutex.Unlock()
+	}()
+
+	// Keep the prior state of an updated resource, rather than the planned values
+	d.Partial(true)
+
+	diags := f()
+
+	// Nothing was created, so nothing must be saved to the state
+	if operation == schema.TimeoutCreate {
+		d.SetId("")
+	}
+
+	plan.mutex.Lock()
+	stopped := plan.stopped
+	plan.mutex.Unlock()
+	if diags.HasError() && !stopped {
+		return diags
+	}
+
+	script := plan.render()
+	log.Printf("[INFO] Dry run of %s %s:\n%s", operation, plan.resource, script)
+
+	if c.config.dryRunPath != "" {
+		if err := appendDryRunScript(c.config.dryRunPath, script); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	return diagnostics(&DryRunError{Script: script})
+}
+
+var dryRunFileMutex sync.Mutex
+
+func appendDryRunScript(path string, script string) error {
+
+	dryRunFileMutex.Lock()
+	defer dryRunFileMutex.Unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not open dry run output: %s", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(script + "\n"); err != nil {
+		return fmt.Errorf("Could not write dry run output: %s", err)
+	}
+	return nil
+}
+
+// withDryRun makes r's Create, Update and Delete honour the provider's dry_run setting
+func withDryRun(r *schema.Resource) *schema.Resource {
+
+	type operationFunc = func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics
+
+	wrap := func(operation string, f operationFunc) operationFunc {
+		return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			client := meta.(*Client)
+			if !client.config.dryRun {
+				return f(ctx, d, meta)
+			}
+			return client.dryRun(d, operation, func() diag.Diagnostics {
+				return f(ctx, d, meta)
+			})
+		}
+	}
+
+	r.CreateContext = wrap(schema.TimeoutCreate, r.CreateContext)
+	if r.UpdateContext != nil {
+		r.UpdateContext = wrap(schema.TimeoutUpdate, r.UpdateContext)
+	}
+	r.DeleteContext = wrap(schema.TimeoutDelete, r.DeleteContext)
+
+	return r
+}
@@ -0,0 +1,68 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func dryRunClient() *Client {
+	client := (&Config{database: "dev", dryRun: true}).Client()
+	client.connections["dev"] = sql.OpenDB(&auditedConnector{connector: &fakeConnector{}})
+	return client
+}
+
+// dryRunScript returns the script reported by the dry run diagnostic in diags
+func dryRunScript(t *testing.T, diags diag.Diagnostics) string {
+	t.Helper()
+	const prefix = "The following SQL would be run:\n"
+	if len(diags) != 1 || diags[0].Summary != "Dry run, nothing was changed" || !strings.HasPrefix(diags[0].Detail, prefix) {
+		t.Fatalf("expected a dry run diagnostic, got %#v", diags)
+	}
+	return strings.TrimPrefix(diags[0].Detail, prefix)
+}
+
+func TestDryRunRendersStatementsInsteadOfRunningThem(t *testing.T) {
+	client := dryRunClient()
+	defer client.Close()
+
+	resource := withDryRun(redshiftGroup())
+	d := schema.TestResourceDataRaw(t, resource.Schema, map[string]interface{}{"group_name": "analysts"})
+
+	script := dryRunScript(t, resource.CreateContext(context.Background(), d, client))
+
+	if expected := "-- create group analysts\nCREATE GROUP \"analysts\";\n"; script != expected {
+		t.Errorf("expected script %q, got %q", expected, script)
+	}
+	if d.Id() != "" {
+		t.Errorf("expected no id to be saved, got %q", d.Id())
+	}
+
+	d.SetId("100")
+	script = dryRunScript(t, resource.DeleteContext(context.Background(), d, client))
+
+	if !strings.Contains(script, `DROP GROUP "analysts";`) {
+		t.Errorf("expected the drop to be rendered, got %q", script)
+	}
+	if d.Id() != "100" {
+		t.Errorf("expected the id to be kept, got %q", d.Id())
+	}
+}
+
+func TestDryRunRedactsPasswords(t *testing.T) {
+	client := dryRunClient()
+	defer client.Close()
+
+	resource := withDryRun(redshiftUser())
+	d := schema.TestResourceDataRaw(t, resource.Schema, map[string]interface{}{"username": "bob", "password": "Secret123"})
+
+	script := dryRunScript(t, resource.CreateContext(context.Background(), d, client))
+
+	if strings.Contains(script, "Secret123") || !strings.Contains(script, `CREATE USER "bob"`) {
+		t.Errorf("unexpected script %q", script)
+	}
+}
@@ -0,0 +1,128 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/lib/pq"
+)
+
+// Error describes a failed operation against Redshift. Operation is what the provider was
+// doing (eg "create user"), Object is what it was doing it to and Code is the SQLSTATE
+// reported by the server, empty when the failure did not come from the server. Attribute names
+// the resource attribute at fault, when there is one.
+type Error struct {
+	Operation string
+	Object    string
+	Code      string
+	Attribute string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	message := "Could not " + e.Operation
+	if e.Object != "" {
+		message += " " + e.Object
+	}
+	if e.Code != "" {
+		message += fmt.Sprintf(" (SQLSTATE %s)", e.Code)
+	}
+	return message + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying driver or validation error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// wrapError returns nil if err is nil, otherwise an *Error for the failed operation.
+// Errors that are already an *Error are returned unchanged so the innermost context wins.
+func wrapError(operation string, object string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+
+	wrapped := &Error{Operation: operation, Object: object, Err: err}
+	if pqErr, ok := err.(*pq.Error); ok {
+		wrapped.Code = string(pqErr.Code)
+	}
+
+	log.Print(wrapped)
+	return wrapped
+}
+
+// newError returns an *Error for a failure detected by the provider itself
+func newError(operation string, object string, message string) error {
+	return &Error{Operation: operation, Object: object, Err: errors.New(message)}
+}
+
+// newAttributeError returns an *Error for a value of attribute the provider rejects
+func newAttributeError(operation string, object string, attribute string, message string) error {
+	return &Error{Operation: operation, Object: object, Attribute: attribute, Err: errors.New(message)}
+}
+
+// diagnostics returns err as the diagnostics of a CRUD function. Errors about an attribute point
+// at it, and a dry run reports the rendered SQL as its detail.
+func diagnostics(err error) diag.Diagnostics {
+
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *DryRunError:
+		return diag.Diagnostics{{
+			Severity: diag.Error,
+			Summary:  "Dry run, nothing was changed",
+			Detail:   "The following SQL would be run:\n" + e.Script,
+		}}
+	case *Error:
+		summary := "Could not " + e.Operation
+		if e.Object != "" {
+			summary += " " + e.Object
+		}
+		d := diag.Diagnostic{Severity: diag.Error, Summary: summary, Detail: e.Err.Error()}
+		if e.Code != "" {
+			d.Detail += fmt.Sprintf(" (SQLSTATE %s)", e.Code)
+		}
+		if e.Attribute != "" {
+			d.AttributePath = cty.GetAttrPath(e.Attribute)
+		}
+		return diag.Diagnostics{d}
+	default:
+		return diag.FromErr(err)
+	}
+}
+
+// withTransaction runs body in a transaction, committing it if body succeeds and rolling it
+// back otherwise. object names what the transaction works on, for error messages. The whole
+// transaction is run again if it fails with a transient error, unless the connection failed during
+// COMMIT, when it is not known whether the transaction was committed. Cancelling ctx rolls the
+// transaction back and cancels the statement in flight.
+func (c *Client) withTransaction(ctx context.Context, db *sql.DB, object string, body func(tx *sql.Tx) error) error {
+
+	return c.withRetry(ctx, object, func() error {
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return wrapError("begin transaction for", object, err)
+		}
+
+		if err := body(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if isConnectionError(err) {
+			err = fmt.Errorf("%w: %s", errCommitUnknown, err)
+		}
+		return wrapError("commit transaction for", object, err)
+	})
+}
@@ -0,0 +1,104 @@
+package redshift
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/lib/pq"
+)
+
+func TestBeginFailureIsReturnedNotPanicked(t *testing.T) {
+	client := fakeClient(&fakeConnector{beginErr: errors.New("connection refused")})
+	defer client.Close()
+
+	resources := map[string]*schema.Resource{
+		"user":                   redshiftUser(),
+		"group":                  redshiftGroup(),
+		"schema_group_privilege": redshiftSchemaGroupPrivilege(),
+		"default_privilege":      redshiftSchemaDefaultUserGroupPrivilege(),
+	}
+
+	for name, resource := range resources {
+		d := resource.TestResourceData()
+		d.SetId("100")
+
+		diags := resource.DeleteContext(context.Background(), d, client)
+
+		if len(diags) != 1 || !strings.HasPrefix(diags[0].Summary, "Could not begin transaction for") || diags[0].Detail != "connection refused" {
+			t.Errorf("%s: unexpected diagnostics %#v", name, diags)
+		}
+	}
+}
+
+func TestServerErrorCarriesSQLState(t *testing.T) {
+	client := fakeClient(&fakeConnector{prepareErr: &pq.Error{Code: "42501", Message: "permission denied"}})
+	defer client.Close()
+
+	d := schema.TestResourceDataRaw(t, redshiftGroup().Schema, map[string]interface{}{"group_name": "analysts"})
+
+	diags := resourceRedshiftGroupCreate(context.Background(), d, client)
+
+	if len(diags) != 1 || diags[0].Summary != "Could not create group analysts" || diags[0].Detail != "pq: permission denied (SQLSTATE 42501)" {
+		t.Errorf("unexpected diagnostics %#v", diags)
+	}
+}
+
+func TestDiagnosticsPointAtAttribute(t *testing.T) {
+	diags := diagnostics(newAttributeError("create", "user bob", "password", "password is not valid"))
+
+	if len(diags) != 1 || diags[0].Severity != diag.Error || diags[0].Summary != "Could not create user bob" {
+		t.Fatalf("unexpected diagnostics %#v", diags)
+	}
+	if !diags[0].AttributePath.Equals(cty.GetAttrPath("password")) {
+		t.Errorf("expected the diagnostic to point at password, got %#v", diags[0].AttributePath)
+	}
+
+	if diags := diagnostics(nil); diags != nil {
+		t.Errorf("expected no diagnostics, got %#v", diags)
+	}
+}
+
+func TestGetUsersnamesForUsesysidReturnsQueryErrors(t *testing.T) {
+	client := fakeClient(&fakeConnector{prepareErr: errors.New("broken pipe")})
+	defer client.Close()
+
+	db, _ := client.getConnection("dev")
+
+	if _, err := GetUsersnamesForUsesysid(context.Background(), db, []interface{}{100}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := GetUsernameForUsesysid(context.Background(), db, 100); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestParseGroupUserList(t *testing.T) {
+	users, err := parseGroupUserList("{100,101}")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(users) != 2 || users[0] != 100 || users[1] != 101 {
+		t.Errorf("unexpected users %v", users)
+	}
+
+	if users, err := parseGroupUserList("{}"); err != nil || len(users) != 0 {
+		t.Errorf("expected no users, got %v, %v", users, err)
+	}
+
+	for _, invalid := range []string{"", "{", "{100,abc}"} {
+		if _, err := parseGroupUserList(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestInvalidSyslogAccessIsRejected(t *testing.T) {
+	if _, errs := redshiftUser().Schema["syslog_access"].ValidateFunc("SOMETIMES", "syslog_access"); len(errs) == 0 {
+		t.Fatal("expected SOMETIMES to be rejected")
+	}
+}
@@ -0,0 +1,142 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// cannedResult is returned for queries containing match
+type cannedResult struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeConnector opens connections to a database that does not exist. Queries get the first
+// canned result they match, or no rows, and statements are recorded and succeed unless they
+// mention "fail". beginErr, prepareErr and commitErr make beginning a transaction, running a
+// statement and committing fail.
+type fakeConnector struct {
+	results []cannedResult
+
+	beginErr   error
+	prepareErr error
+	commitErr  error
+
+	mu       sync.Mutex
+	executed []string
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{connector: c}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	connector *fakeConnector
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if c.connector.prepareErr != nil {
+		return nil, c.connector.prepareErr
+	}
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if c.connector.beginErr != nil {
+		return nil, c.connector.beginErr
+	}
+	return &fakeTx{connector: c.connector}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.connector.prepareErr != nil {
+		return nil, c.connector.prepareErr
+	}
+
+	c.connector.mu.Lock()
+	c.connector.executed = append(c.connector.executed, query)
+	c.connector.mu.Unlock()
+
+	if strings.Contains(query, "fail") {
+		return nil, errors.New("syntax error")
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.connector.prepareErr != nil {
+		return nil, c.connector.prepareErr
+	}
+	for _, result := range c.connector.results {
+		if strings.Contains(query, result.match) {
+			return &fakeRows{columns: result.columns, rows: result.rows}, nil
+		}
+	}
+	return &fakeRows{columns: []string{"result"}}, nil
+}
+
+type fakeTx struct {
+	connector *fakeConnector
+}
+
+func (tx *fakeTx) Commit() error {
+	return tx.connector.commitErr
+}
+
+func (tx *fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// fakeClient returns a client of the dev database whose connections are opened by connector
+func fakeClient(connector *fakeConnector) *Client {
+	client := (&Config{database: "dev"}).Client()
+	client.connections["dev"] = sql.OpenDB(connector)
+	return client
+}
+
+func cannedClient(results ...cannedResult) *Client {
+	return fakeClient(&fakeConnector{results: results})
+}
+
+// cannedClientConnector returns a client answering with results and the connector of its
+// connections
+func cannedClientConnector(results ...cannedResult) (*Client, *fakeConnector) {
+	connector := &fakeConnector{results: results}
+	return fakeClient(connector), connector
+}
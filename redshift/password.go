@@ -0,0 +1,152 @@
+package redshift
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// https://www.postgresql.org/docs/current/libpq-pgpass.html
+
+// passwordCommandTimeout is how long password_command may run before it is killed
+const passwordCommandTimeout = 1 * time.Minute
+
+// runPasswordCommand runs command with the shell and returns what it prints, without the
+// trailing newline
+func runPasswordCommand(ctx context.Context, command string) (string, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, passwordCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.Printf("[DEBUG] Running password_command")
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("password_command did not finish within %s", passwordCommandTimeout)
+		}
+		// The command is not included, it may contain secrets
+		return "", fmt.Errorf("password_command failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	password := strings.TrimRight(stdout.String(), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password_command did not print a password")
+	}
+	return password, nil
+}
+
+// pgpassFile returns PGPASSFILE, or the default location of the password file
+func pgpassFile() string {
+
+	if path := os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// passwordFromPgpass returns the password of the first line of the password file at path
+// matching host, port, database and user. Like libpq, a missing file and a file readable by
+// others are ignored, and * matches any value.
+func passwordFromPgpass(path string, host string, port string, database string, user string) (string, bool, error) {
+
+	if path == "" {
+		return "", false, nil
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("Could not read password file %s: %s", path, err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		log.Printf("[WARN] Password file %s has group or world access, it is ignored. Permissions should be u=rw (0600) or less", path)
+		return "", false, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, fmt.Errorf("Could not read password file %s: %s", path, err)
+	}
+	defer file.Close()
+
+	want := []string{host, port, database, user}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+
+		matches := true
+		for i, value := range want {
+			if fields[i] != "*" && fields[i] != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return fields[4], true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", false, fmt.Errorf("Could not read password file %s: %s", path, err)
+	}
+
+	return "", false, nil
+}
+
+// splitPgpassLine splits a line on colons, unescaping \: and \\
+func splitPgpassLine(line string) []string {
+
+	var fields []string
+	var field strings.Builder
+
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':' && len(fields) < 4:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+
+	return append(fields, field.String())
+}
@@ -0,0 +1,155 @@
+package redshift
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// https://docs.aws.amazon.com/redshift/latest/dg/r_CREATE_USER.html
+// Passwords are 8 to 64 characters long, with at least one uppercase letter, one lowercase
+// letter and one number. Any printable ASCII character except ' " \ / @ and space can be used.
+
+const (
+	passwordUppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	passwordLowercase = "abcdefghijklmnopqrstuvwxyz"
+	passwordDigits    = "0123456789"
+	passwordSymbols   = "!#$%&()*+,-.:;<=>?[]^_{|}~"
+)
+
+// keybaseLookupURL is where public keys of keybase: identifiers are looked up, replaced in tests
+var keybaseLookupURL = "https://keybase.io/_/api/1.0/user/lookup.json"
+
+// generatePassword returns a random password of length characters satisfying the complexity
+// rules of Redshift
+func generatePassword(length int) (string, error) {
+
+	if length < 8 || length > 64 {
+		return "", fmt.Errorf("Password length has to be between 8 and 64, got %d", length)
+	}
+
+	characters := passwordUppercase + passwordLowercase + passwordDigits + passwordSymbols
+	max := big.NewInt(int64(len(characters)))
+
+	// Drawing again until every class is present keeps the characters uniformly distributed
+	for {
+		password := make([]byte, length)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", fmt.Errorf("Could not generate a password: %s", err)
+			}
+			password[i] = characters[n.Int64()]
+		}
+
+		if strings.ContainsAny(string(password), passwordUppercase) &&
+			strings.ContainsAny(string(password), passwordLowercase) &&
+			strings.ContainsAny(string(password), passwordDigits) {
+			return string(password), nil
+		}
+	}
+}
+
+// readPGPKey returns the public key pgpKey names: a base64 encoded or armored public key, or a
+// keybase:username whose primary key is looked up on keybase
+func readPGPKey(pgpKey string) (*openpgp.Entity, error) {
+
+	if strings.HasPrefix(pgpKey, "keybase:") {
+		armored, err := fetchKeybaseKey(strings.TrimPrefix(pgpKey, "keybase:"))
+		if err != nil {
+			return nil, err
+		}
+		pgpKey = armored
+	}
+
+	var (
+		entities openpgp.EntityList
+		err      error
+	)
+	if strings.HasPrefix(strings.TrimSpace(pgpKey), "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		entities, err = openpgp.ReadArmoredKeyRing(strings.NewReader(pgpKey))
+	} else {
+		var decoded []byte
+		decoded, err = base64.StdEncoding.DecodeString(strings.TrimSpace(pgpKey))
+		if err != nil {
+			return nil, fmt.Errorf("pgp_key is neither an armored nor a base64 encoded public key: %s", err)
+		}
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(decoded))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read pgp_key: %s", err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("pgp_key does not contain a public key")
+	}
+	return entities[0], nil
+}
+
+// fetchKeybaseKey returns the armored primary public key of a keybase user
+func fetchKeybaseKey(username string) (string, error) {
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Get(keybaseLookupURL + "?fields=public_keys&usernames=" + url.QueryEscape(username))
+	if err != nil {
+		return "", fmt.Errorf("Could not look up keybase:%s: %s", username, err)
+	}
+	defer response.Body.Close()
+
+	var lookup struct {
+		Status struct {
+			Code int    `json:"code"`
+			Desc string `json:"desc"`
+		} `json:"status"`
+		Them []struct {
+			PublicKeys struct {
+				Primary struct {
+					Bundle string `json:"bundle"`
+				} `json:"primary"`
+			} `json:"public_keys"`
+		} `json:"them"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&lookup); err != nil {
+		return "", fmt.Errorf("Could not look up keybase:%s: %s", username, err)
+	}
+	if lookup.Status.Code != 0 {
+		return "", fmt.Errorf("Could not look up keybase:%s: %s", username, lookup.Status.Desc)
+	}
+	if len(lookup.Them) == 0 || lookup.Them[0].PublicKeys.Primary.Bundle == "" {
+		return "", fmt.Errorf("keybase:%s has no public key", username)
+	}
+	return lookup.Them[0].PublicKeys.Primary.Bundle, nil
+}
+
+// encryptPassword encrypts password for pgpKey, returning the fingerprint of the key and the
+// base64 encoded message, which decrypts with: base64 --decode | gpg --decrypt
+func encryptPassword(pgpKey string, password string) (fingerprint string, encrypted string, err error) {
+
+	entity, err := readPGPKey(pgpKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	var message bytes.Buffer
+	w, err := openpgp.Encrypt(&message, openpgp.EntityList{entity}, nil, nil, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("Could not encrypt password: %s", err)
+	}
+	if _, err := w.Write([]byte(password)); err != nil {
+		return "", "", fmt.Errorf("Could not encrypt password: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", "", fmt.Errorf("Could not encrypt password: %s", err)
+	}
+
+	return hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]), base64.StdEncoding.EncodeToString(message.Bytes()), nil
+}
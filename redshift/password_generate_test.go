@@ -0,0 +1,197 @@
+package redshift
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	for _, length := range []int{8, 32, 64} {
+		password, err := generatePassword(length)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if len(password) != length {
+			t.Errorf("expected %d characters, got %q", length, password)
+		}
+		for _, class := range []string{passwordUppercase, passwordLowercase, passwordDigits} {
+			if !strings.ContainsAny(password, class) {
+				t.Errorf("expected %q to contain one of %s", password, class)
+			}
+		}
+		if strings.ContainsAny(password, `'"\/@ `) {
+			t.Errorf("expected %q not to contain characters Redshift rejects", password)
+		}
+	}
+
+	for _, length := range []int{7, 65} {
+		if _, err := generatePassword(length); err == nil {
+			t.Errorf("expected a length of %d to be rejected", length)
+		}
+	}
+}
+
+func generatePGPKey(t *testing.T) (*openpgp.Entity, string, string) {
+	entity, err := openpgp.NewEntity("Terraform", "test", "terraform@example.com", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var public bytes.Buffer
+	if err := entity.Serialize(&public); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	w.Write(public.Bytes())
+	w.Close()
+
+	return entity, base64.StdEncoding.EncodeToString(public.Bytes()), armored.String()
+}
+
+func decryptPassword(t *testing.T, entity *openpgp.Entity, encrypted string) string {
+	message, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	details, err := openpgp.ReadMessage(bytes.NewReader(message), openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	password, err := ioutil.ReadAll(details.UnverifiedBody)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return string(password)
+}
+
+func TestEncryptPassword(t *testing.T) {
+	entity, publicKey, armoredKey := generatePGPKey(t)
+	expectedFingerprint := hex.EncodeToString(entity.PrimaryKey.Fingerprint[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("usernames") != "terraform" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": map[string]interface{}{"code": 205, "desc": "Not found"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": map[string]interface{}{"code": 0},
+			"them":   []interface{}{map[string]interface{}{"public_keys": map[string]interface{}{"primary": map[string]interface{}{"bundle": armoredKey}}}},
+		})
+	}))
+	defer server.Close()
+
+	defer func(lookupURL string) { keybaseLookupURL = lookupURL }(keybaseLookupURL)
+	keybaseLookupURL = server.URL
+
+	for _, pgpKey := range []string{publicKey, armoredKey, "keybase:terraform"} {
+		fingerprint, encrypted, err := encryptPassword(pgpKey, "Generated1")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if fingerprint != expectedFingerprint {
+			t.Errorf("expected fingerprint %s, got %s", expectedFingerprint, fingerprint)
+		}
+		if password := decryptPassword(t, entity, encrypted); password != "Generated1" {
+			t.Errorf("expected the password to decrypt, got %q", password)
+		}
+	}
+
+	for _, invalid := range []string{"not a key", base64.StdEncoding.EncodeToString([]byte("not a key")), "keybase:nobody"} {
+		if _, _, err := encryptPassword(invalid, "Generated1"); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestGeneratedPasswordDiff(t *testing.T) {
+	_, publicKey, _ := generatePGPKey(t)
+
+	diff := func(raw map[string]interface{}) (*terraform.InstanceDiff, error) {
+		return redshiftUser().Diff(context.Background(), nil, terraform.NewResourceConfigRaw(raw), nil)
+	}
+
+	if _, err := diff(map[string]interface{}{"username": "service", "generate_password": true}); err == nil {
+		t.Error("expected an error when pgp_key is not set")
+	}
+	both := terraform.NewResourceConfigRaw(map[string]interface{}{"username": "service", "generate_password": true, "pgp_key": publicKey, "store_plaintext_password": true})
+	if diags := redshiftUser().Validate(both); !diags.HasError() {
+		t.Error("expected an error when pgp_key and store_plaintext_password are both set")
+	}
+
+	plainDiff, err := diff(map[string]interface{}{"username": "service", "generate_password": true, "store_plaintext_password": true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if a, ok := plainDiff.Attributes["generated_password"]; !ok || !a.NewComputed || !a.Sensitive {
+		t.Errorf("expected generated_password to be computed and sensitive with store_plaintext_password, got %#v", a)
+	}
+	if _, err := diff(map[string]interface{}{"username": "service", "generate_password": true, "pgp_key": publicKey, "password_disabled": true}); err == nil {
+		t.Error("expected an error when the password is also disabled")
+	}
+
+	instanceDiff, err := diff(map[string]interface{}{"username": "service", "generate_password": true, "pgp_key": publicKey})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, attribute := range []string{"encrypted_password", "key_fingerprint"} {
+		if a, ok := instanceDiff.Attributes[attribute]; !ok || !a.NewComputed {
+			t.Errorf("expected %s to be computed, got %#v", attribute, a)
+		}
+	}
+}
+
+func TestGenerateUserPasswordInPlainText(t *testing.T) {
+	d := redshiftUser().TestResourceData()
+	d.Set("username", "service")
+	d.Set("generate_password", true)
+	d.Set("password_length", 32)
+
+	if _, err := generateUserPassword(d); err == nil {
+		t.Error("expected an error without pgp_key or store_plaintext_password")
+	}
+	if generated := d.Get("generated_password").(string); generated != "" {
+		t.Errorf("expected no plain text password to be stored, got %q", generated)
+	}
+
+	d.Set("store_plaintext_password", true)
+	password, err := generateUserPassword(d)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if generated := d.Get("generated_password").(string); generated != password {
+		t.Errorf("expected generated_password to hold the password, got %q", generated)
+	}
+}
+
+func TestResetPasswordStatementWithoutPassword(t *testing.T) {
+	d := redshiftUser().TestResourceData()
+	d.Set("username", "service")
+	d.Set("generate_password", true)
+
+	if statement, err := resetPasswordStatement(d, "service", ""); err != nil || statement != "" {
+		t.Errorf("expected nothing to be done, got %q %v", statement, err)
+	}
+
+	d.Set("valid_until", "2030-01-01")
+	expected := `ALTER USER "service" VALID UNTIL '2030-01-01'`
+	if statement, err := resetPasswordStatement(d, "service", ""); err != nil || statement != expected {
+		t.Errorf("expected %s, got %q %v", expected, statement, err)
+	}
+}
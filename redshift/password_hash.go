@@ -0,0 +1,112 @@
+package redshift
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// https://docs.aws.amazon.com/redshift/latest/dg/r_CREATE_USER.html
+// A password can be given as md5 followed by the md5 digest of the password and user name,
+// which is also how it is stored, or as sha256|digest|salt where digest is the sha256 digest of
+// the password followed by the salt
+
+// Values of password_hash_method
+const (
+	passwordHashNone   = "none"
+	passwordHashMD5    = "md5"
+	passwordHashSHA256 = "sha256"
+)
+
+// sha256SaltBytes is the number of random bytes in a salt, hex encoded in the hash
+const sha256SaltBytes = 8
+
+// md5PasswordHash returns the hash Redshift stores for password when it is set for username
+func md5PasswordHash(password string, username string) string {
+	sum := md5.Sum([]byte(password + username))
+	return "md5" + hex.EncodeToString(sum[:])
+}
+
+func isMD5PasswordHash(value string) bool {
+	return md5HashPattern.MatchString(value) && len(value) == len("md5")+32
+}
+
+// sha256PasswordHash returns the sha256|digest|salt form of password
+func sha256PasswordHash(password string, salt string) string {
+	sum := sha256.Sum256([]byte(password + salt))
+	return "sha256|" + hex.EncodeToString(sum[:]) + "|" + salt
+}
+
+// hashPassword returns password hashed with method for username. Passwords that are already
+// hashed are returned as they are.
+func hashPassword(method string, password string, username string) (string, error) {
+
+	switch method {
+	case passwordHashMD5:
+		if isMD5PasswordHash(password) {
+			return password, nil
+		}
+		return md5PasswordHash(password, username), nil
+	case passwordHashSHA256:
+		if strings.HasPrefix(strings.ToLower(password), "sha256|") {
+			return password, nil
+		}
+		salt := make([]byte, sha256SaltBytes)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("Could not generate a salt: %s", err)
+		}
+		return sha256PasswordHash(password, hex.EncodeToString(salt)), nil
+	default:
+		return password, nil
+	}
+}
+
+// passwordMatchesHash reports whether password, plain text or an md5 hash, is the one stored as
+// storedHash for username. ok is false when storedHash is not an md5 hash, which can not be
+// compared client side.
+func passwordMatchesHash(password string, username string, storedHash string) (matches bool, ok bool) {
+
+	if !isMD5PasswordHash(storedHash) {
+		return false, false
+	}
+
+	expected := password
+	if !isMD5PasswordHash(password) {
+		expected = md5PasswordHash(password, username)
+	}
+	return strings.EqualFold(expected, storedHash), true
+}
+
+// readPasswordDrift compares the password in state with the hash stored by the cluster, and
+// clears the password in state when they differ, so the next plan resets it
+func readPasswordDrift(ctx context.Context, d *schema.ResourceData, tx *sql.Tx) error {
+
+	password := d.Get("password").(string)
+	if password == "" || d.Get("password_disabled").(bool) {
+		return nil
+	}
+
+	var passwd sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT passwd FROM pg_user_info WHERE usesysid = $1", d.Id()).Scan(&passwd); err != nil {
+		return wrapError("read password hash of", userObject(d), err)
+	}
+
+	matches, ok := passwordMatchesHash(password, d.Get("username").(string), passwd.String)
+	if !ok {
+		log.Printf("[DEBUG] The password of %s is not stored as an md5 hash, it can not be checked for changes", userObject(d))
+		return nil
+	}
+	if !matches {
+		log.Printf("[INFO] The password of %s was changed outside of Terraform", userObject(d))
+		d.Set("password", "")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package redshift
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const testusernewHash = "md5cb787f17ad1fea97d316c20eeb0ebab5"
+
+func TestMD5PasswordHash(t *testing.T) {
+	if hash := md5PasswordHash("Testpass123", "testusernew"); hash != testusernewHash {
+		t.Errorf("expected %s, got %s", testusernewHash, hash)
+	}
+}
+
+func TestPasswordMatchesHash(t *testing.T) {
+	cases := []struct {
+		password   string
+		storedHash string
+		matches    bool
+		ok         bool
+	}{
+		{"Testpass123", testusernewHash, true, true},
+		{"Changed123", testusernewHash, false, true},
+		{testusernewHash, testusernewHash, true, true},
+		{"MD5CB787F17AD1FEA97D316C20EEB0EBAB5", testusernewHash, true, true},
+		{"Testpass123", "sha256|c1b3a6b5|0bd5ba4a30d8c51f9b7f2ad8f2e2f6e5", false, false},
+		{"Testpass123", "********", false, false},
+		{"Testpass123", "", false, false},
+	}
+
+	for _, c := range cases {
+		matches, ok := passwordMatchesHash(c.password, "testusernew", c.storedHash)
+		if matches != c.matches || ok != c.ok {
+			t.Errorf("%q against %q: expected %v %v, got %v %v", c.password, c.storedHash, c.matches, c.ok, matches, ok)
+		}
+	}
+}
+
+func TestReadPasswordDrift(t *testing.T) {
+	client := cannedClient(cannedResult{match: "passwd", columns: []string{"passwd"}, rows: [][]driver.Value{{testusernewHash}}})
+	defer client.Close()
+
+	readDrift := func(password string) string {
+		d := schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{
+			"username": "testusernew",
+			"password": password,
+		})
+		d.SetId("100")
+
+		err := client.withTransaction(context.Background(), client.connections["dev"], userObject(d), func(tx *sql.Tx) error {
+			return readPasswordDrift(context.Background(), d, tx)
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return d.Get("password").(string)
+	}
+
+	if password := readDrift("Testpass123"); password != "Testpass123" {
+		t.Errorf("expected an unchanged password to be kept, got %q", password)
+	}
+	if password := readDrift("Changed123"); password != "" {
+		t.Errorf("expected a changed password to be cleared, got %q", password)
+	}
+}
+
+func TestDetectsPasswordDrift(t *testing.T) {
+	client := cannedClient(
+		cannedResult{match: "version()", columns: []string{"version"}, rows: [][]driver.Value{{"Redshift 1.0.12103"}}},
+		cannedResult{match: "usesuper", columns: []string{"usesuper"}, rows: [][]driver.Value{{true}}},
+	)
+	defer client.Close()
+	db := client.connections["dev"]
+
+	if client.detectsPasswordDrift(context.Background(), db) {
+		t.Error("expected no drift detection when it is turned off")
+	}
+	if client.capabilities != nil {
+		t.Error("expected the capabilities not to be detected when drift detection is turned off")
+	}
+
+	// The capabilities are detected through the resource database when they are not known yet
+	client.config.passwordDriftDetection = true
+	if !client.detectsPasswordDrift(context.Background(), db) {
+		t.Error("expected drift detection for a superuser")
+	}
+	if client.capabilities == nil || client.capabilities.build != 12103 {
+		t.Errorf("expected the capabilities to be detected, got %v", client.capabilities)
+	}
+
+	client.capabilities.superuser = false
+	if client.detectsPasswordDrift(context.Background(), db) {
+		t.Error("expected no drift detection for a user who can not read password hashes")
+	}
+
+	failing := fakeClient(&fakeConnector{prepareErr: errors.New("connection refused")})
+	defer failing.Close()
+	failing.config.passwordDriftDetection = true
+	if failing.detectsPasswordDrift(context.Background(), failing.connections["dev"]) {
+		t.Error("expected no drift detection when the capabilities can not be detected")
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	if hash, _ := hashPassword(passwordHashNone, "Testpass123", "testusernew"); hash != "Testpass123" {
+		t.Errorf("expected the password to be sent as it is, got %s", hash)
+	}
+
+	if hash, _ := hashPassword(passwordHashMD5, "Testpass123", "testusernew"); hash != testusernewHash {
+		t.Errorf("expected %s, got %s", testusernewHash, hash)
+	}
+	if hash, _ := hashPassword(passwordHashMD5, testusernewHash, "testusernew"); hash != testusernewHash {
+		t.Errorf("expected an md5 hash to be kept, got %s", hash)
+	}
+
+	// sha256|hex digest of password followed by salt|salt
+	sha256Format := regexp.MustCompile(`^sha256\|([0-9a-f]{64})\|([0-9a-f]{16})$`)
+
+	first, err := hashPassword(passwordHashSHA256, "Mypassw0rd", "testusernew")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	match := sha256Format.FindStringSubmatch(first)
+	if match == nil {
+		t.Fatalf("unexpected sha256 hash format %s", first)
+	}
+	sum := sha256.Sum256([]byte("Mypassw0rd" + match[2]))
+	if hex.EncodeToString(sum[:]) != match[1] {
+		t.Errorf("expected the digest of the password followed by the salt, got %s", first)
+	}
+
+	second, _ := hashPassword(passwordHashSHA256, "Mypassw0rd", "testusernew")
+	if first == second {
+		t.Error("expected a new salt for every hash")
+	}
+
+	if hash, _ := hashPassword(passwordHashSHA256, "sha256|Mypassw0rd", "testusernew"); hash != "sha256|Mypassw0rd" {
+		t.Errorf("expected a sha256 password to be kept, got %s", hash)
+	}
+
+	// The salt of the example in the CREATE USER documentation
+	expected := "sha256|0ae5f7fbc7f20c38ea75140d354c222eeb666cfeba9031a6dcf6dbcfb06cdd49|c721bff"
+	if hash := sha256PasswordHash("Mypassw0rd", "c721bff"); hash != expected {
+		t.Errorf("expected %s, got %s", expected, hash)
+	}
+}
+
+func TestCreateUserStatementHashesPassword(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{
+		"username":             "testusernew",
+		"password":             "Testpass123",
+		"password_hash_method": "md5",
+	})
+
+	statement, err := createUserStatement(d, d.Get("password").(string))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(statement, "PASSWORD '"+testusernewHash+"'") || strings.Contains(statement, "Testpass123") {
+		t.Errorf("expected only the hash to be sent, got %s", statement)
+	}
+}
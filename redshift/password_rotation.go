@@ -0,0 +1,34 @@
+package redshift
+
+import (
+	"log"
+	"time"
+)
+
+// rotationExpiryLayout is how valid_until is written for rotated passwords, a timestamp the
+// cluster reads in UTC
+const rotationExpiryLayout = "2006-01-02 15:04:05"
+
+// rotationDue reports whether a password last rotated at lastRotatedAt, in RFC 3339, has to be
+// rotated at now. Passwords whose last rotation is not known are due.
+func rotationDue(lastRotatedAt string, rotationDays int, now time.Time) bool {
+
+	if rotationDays <= 0 {
+		return false
+	}
+
+	rotatedAt, err := time.Parse(time.RFC3339, lastRotatedAt)
+	if err != nil {
+		if lastRotatedAt != "" {
+			log.Printf("[WARN] Could not read last_rotated_at %q, rotating the password: %s", lastRotatedAt, err)
+		}
+		return true
+	}
+	return !now.Before(rotatedAt.AddDate(0, 0, rotationDays))
+}
+
+// rotationExpiry returns the valid_until of a password rotated at rotatedAt, the moment it is due
+// to be rotated again
+func rotationExpiry(rotatedAt time.Time, rotationDays int) string {
+	return rotatedAt.UTC().AddDate(0, 0, rotationDays).Format(rotationExpiryLayout)
+}
@@ -0,0 +1,93 @@
+package redshift
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestRotationDue(t *testing.T) {
+	now := time.Date(2030, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		lastRotatedAt string
+		rotationDays  int
+		due           bool
+	}{
+		{"2030-03-01T12:00:00Z", 0, false},
+		{"", 0, false},
+		{"2030-03-01T12:00:00Z", 90, false},
+		{"2030-01-01T12:00:00Z", 90, true},
+		{"2030-01-01T12:00:01Z", 90, false},
+		{"2030-01-01T14:00:00+02:00", 90, true},
+		{"", 90, true},
+		{"yesterday", 90, true},
+	}
+
+	for _, c := range cases {
+		if due := rotationDue(c.lastRotatedAt, c.rotationDays, now); due != c.due {
+			t.Errorf("%q every %d days: expected %v, got %v", c.lastRotatedAt, c.rotationDays, c.due, due)
+		}
+	}
+}
+
+func TestRotationExpiry(t *testing.T) {
+	rotatedAt := time.Date(2030, 1, 1, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	if expiry := rotationExpiry(rotatedAt, 90); expiry != "2030-04-01 12:30:00" {
+		t.Errorf("expected 2030-04-01 12:30:00, got %s", expiry)
+	}
+}
+
+func TestRotationDiff(t *testing.T) {
+	diff := func(lastRotatedAt string, raw map[string]interface{}) (*terraform.InstanceDiff, error) {
+		state := &terraform.InstanceState{
+			ID: "100",
+			Attributes: map[string]string{
+				"id":                       "100",
+				"username":                 "service",
+				"generate_password":        "true",
+				"store_plaintext_password": "true",
+				"password_length":          "32",
+				"rotation_days":            "90",
+				"keepers.%":                "1",
+				"keepers.release":          "1",
+				"last_rotated_at":          lastRotatedAt,
+				"generated_password":       "Generated1",
+			},
+		}
+		return redshiftUser().Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), nil)
+	}
+	configured := func(release string) map[string]interface{} {
+		return map[string]interface{}{
+			"username":                 "service",
+			"generate_password":        true,
+			"store_plaintext_password": true,
+			"rotation_days":            90,
+			"keepers":                  map[string]interface{}{"release": release},
+		}
+	}
+	rotated := func(d *terraform.InstanceDiff) bool {
+		a, ok := d.Attributes["generated_password"]
+		return ok && a.NewComputed
+	}
+
+	recently := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	longAgo := time.Now().UTC().AddDate(0, 0, -91).Format(time.RFC3339)
+
+	if d, err := diff(recently, configured("1")); err != nil || rotated(d) {
+		t.Errorf("expected no rotation of a recent password, got %#v %v", d, err)
+	}
+	if d, err := diff(longAgo, configured("1")); err != nil || !rotated(d) || !d.Attributes["valid_until"].NewComputed {
+		t.Errorf("expected a due password to be rotated, got %#v %v", d, err)
+	}
+	if d, err := diff(recently, configured("2")); err != nil || !rotated(d) {
+		t.Errorf("expected changed keepers to rotate the password, got %#v %v", d, err)
+	}
+
+	raw := map[string]interface{}{"username": "service", "rotation_days": 90}
+	if _, err := redshiftUser().Diff(context.Background(), nil, terraform.NewResourceConfigRaw(raw), nil); err == nil {
+		t.Error("expected an error when rotation_days is set without generate_password")
+	}
+}
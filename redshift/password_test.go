@@ -0,0 +1,206 @@
+package redshift
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func writePgpass(t *testing.T, content string, mode os.FileMode) (string, func()) {
+	dir, err := ioutil.TempDir("", "redshift-pgpass-test")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	path := filepath.Join(dir, ".pgpass")
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// WriteFile is subject to the umask
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestPasswordFromPgpass(t *testing.T) {
+	path, cleanup := writePgpass(t, strings.Join([]string{
+		"# comment",
+		"other.example.com:5439:dev:terraform:wrong",
+		"cluster.example.com:5439:analytics:terraform:analytics",
+		`cluster.example.com:5439:*:terraform:se\:cr\\et:with colon`,
+		"*:*:*:*:fallback",
+	}, "\n"), 0600)
+	defer cleanup()
+
+	cases := []struct {
+		host, port, database, user string
+		expected                   string
+	}{
+		{"cluster.example.com", "5439", "analytics", "terraform", "analytics"},
+		{"cluster.example.com", "5439", "dev", "terraform", `se:cr\et:with colon`},
+		{"cluster.example.com", "5440", "dev", "terraform", "fallback"},
+	}
+
+	for _, tc := range cases {
+		password, found, err := passwordFromPgpass(path, tc.host, tc.port, tc.database, tc.user)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !found || password != tc.expected {
+			t.Errorf("%s:%s:%s:%s: expected %q, got %q (found %v)", tc.host, tc.port, tc.database, tc.user, tc.expected, password, found)
+		}
+	}
+
+	if _, found, err := passwordFromPgpass(filepath.Join(filepath.Dir(path), "missing"), "h", "1", "d", "u"); found || err != nil {
+		t.Errorf("expected a missing file to be ignored, got %v %v", found, err)
+	}
+}
+
+func TestPasswordFromPgpassIgnoresSharedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on windows")
+	}
+
+	path, cleanup := writePgpass(t, "*:*:*:*:secret\n", 0644)
+	defer cleanup()
+
+	if _, found, err := passwordFromPgpass(path, "h", "1", "d", "u"); found || err != nil {
+		t.Errorf("expected a world readable file to be ignored, got %v %v", found, err)
+	}
+}
+
+func TestRunPasswordCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands need a POSIX shell")
+	}
+
+	password, err := runPasswordCommand(context.Background(), "echo s3cret")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if password != "s3cret" {
+		t.Errorf("expected s3cret, got %q", password)
+	}
+
+	_, err = runPasswordCommand(context.Background(), "echo vault is sealed >&2; exit 3")
+	if err == nil || !strings.Contains(err.Error(), "vault is sealed") {
+		t.Errorf("expected the error output of the command, got %v", err)
+	}
+
+	if _, err := runPasswordCommand(context.Background(), "true"); err == nil {
+		t.Error("expected an empty password to be rejected")
+	}
+}
+
+func TestProviderConfigureFromEnvironment(t *testing.T) {
+	path, cleanup := writePgpass(t, "cluster.example.com:5440:analytics:ci:from-pgpass\n", 0600)
+	defer cleanup()
+
+	environment := map[string]string{
+		"REDSHIFT_HOST":     "cluster.example.com",
+		"REDSHIFT_USER":     "ci",
+		"REDSHIFT_PASSWORD": "",
+		"REDSHIFT_PORT":     "5440",
+		"REDSHIFT_DATABASE": "analytics",
+		"PGPASSFILE":        path,
+		"ALL_PROXY":         "",
+		"all_proxy":         "",
+	}
+	for name, value := range environment {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, value)
+	}
+
+	provider := Provider()
+
+	configure := func(raw map[string]interface{}) (*Client, error) {
+		client, diags := providerClient(schema.TestResourceDataRaw(t, provider.Schema, raw), context.Background())
+		if diags.HasError() {
+			return nil, errors.New(diags[0].Summary)
+		}
+		return client, nil
+	}
+
+	client, err := configure(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer client.Close()
+
+	if client.config.url != "cluster.example.com" || client.config.port != "5440" || client.config.database != "analytics" {
+		t.Errorf("expected the connection settings from the environment, got %s:%s/%s", client.config.url, client.config.port, client.config.database)
+	}
+	if user, password, _ := client.config.credentials.credentials(context.Background()); user != "ci" || password != "from-pgpass" {
+		t.Errorf("expected the password from the password file, got %s %q", user, password)
+	}
+
+	os.Setenv("REDSHIFT_PASSWORD", "from-environment")
+	client, err = configure(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer client.Close()
+	if _, password, _ := client.config.credentials.credentials(context.Background()); password != "from-environment" {
+		t.Errorf("expected REDSHIFT_PASSWORD to take precedence over the password file, got %q", password)
+	}
+
+	client, err = configure(map[string]interface{}{"password_command": "echo from-command"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer client.Close()
+	if _, password, _ := client.config.credentials.credentials(context.Background()); password != "from-command" {
+		t.Errorf("expected password_command to take precedence over REDSHIFT_PASSWORD, got %q", password)
+	}
+	os.Setenv("REDSHIFT_PASSWORD", "")
+
+	if _, err := configure(map[string]interface{}{"database": "dev"}); err == nil {
+		t.Error("expected an error when no password is configured for the database")
+	}
+}
+
+func TestProviderPasswordCommandOverridesEnvironment(t *testing.T) {
+	environment := map[string]string{
+		"REDSHIFT_PASSWORD": "from-environment",
+		"REDSHIFT_DATABASE": "",
+		"ALL_PROXY":         "",
+		"all_proxy":         "",
+	}
+	for name, value := range environment {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, value)
+	}
+
+	raw := map[string]interface{}{
+		"url":              "127.0.0.1",
+		"user":             "ci",
+		"password_command": "echo from-command",
+	}
+
+	provider := Provider()
+	if diags := provider.Validate(terraform.NewResourceConfigRaw(raw)); diags.HasError() {
+		t.Fatalf("expected REDSHIFT_PASSWORD not to conflict with password_command, got %#v", diags)
+	}
+	if diags := provider.Configure(context.Background(), terraform.NewResourceConfigRaw(raw)); diags.HasError() {
+		t.Fatalf("unexpected diagnostics %#v", diags)
+	}
+	client := provider.Meta().(*Client)
+	defer client.Close()
+	if _, password, _ := client.config.credentials.credentials(context.Background()); password != "from-command" {
+		t.Errorf("expected password_command to take precedence over REDSHIFT_PASSWORD, got %q", password)
+	}
+
+	raw["password"] = "Secret123"
+	diags := Provider().Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "password and password_command cannot both be configured") {
+		t.Errorf("expected password and password_command to conflict, got %#v", diags)
+	}
+}
@@ -0,0 +1,403 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/lib/pq"
+)
+
+// A user or group can only be dropped once nothing is granted to it in any database of the
+// cluster. The catalog of each database only lists the grants of that database, so every database
+// in pg_database_info is connected to in turn, and the ACLs that name the grantee are revoked one
+// by one, rather than revoking everything everywhere and ignoring what fails.
+//
+// https://docs.aws.amazon.com/redshift/latest/dg/r_REVOKE.html
+
+// teardownSkippedDatabases can not be connected to or hold no grants
+var teardownSkippedDatabases = map[string]bool{
+	"template0":    true,
+	"template1":    true,
+	"padb_harvest": true,
+}
+
+// defaultPrivilegeObjects are the objects of each pg_default_acl.defaclobjtype
+var defaultPrivilegeObjects = map[string]string{
+	"r": "TABLES",
+	"f": "FUNCTIONS",
+	"p": "PROCEDURES",
+}
+
+// grantee is the user or group whose privileges are torn down
+type grantee struct {
+	name  string
+	group bool
+}
+
+func (g grantee) String() string {
+	if g.group {
+		return "group " + g.name
+	}
+	return "user " + g.name
+}
+
+// clause returns g as it follows FROM in a REVOKE statement
+func (g grantee) clause() string {
+	if g.group {
+		return "GROUP " + sqlbuilder.QuoteIdentifier(g.name)
+	}
+	return sqlbuilder.QuoteIdentifier(g.name)
+}
+
+// revokedPrivilege is a statement the teardown ran and the database it ran in
+type revokedPrivilege struct {
+	database  string
+	object    string
+	statement string
+}
+
+func (r revokedPrivilege) String() string {
+	return r.object + " in database " + r.database
+}
+
+// aclGrantee parses the grantee of an aclitem such as group "data team"=r/admin. The grantee
+// of PUBLIC is empty.
+func aclGrantee(item string) (grantee, bool) {
+	g, _, ok := parseACLItem(item)
+	return g, ok
+}
+
+// parseACLItem parses an aclitem into its grantee and the privileges granted, the letters
+// between = and the grantor
+func parseACLItem(item string) (grantee, string, bool) {
+
+	var g grantee
+	if strings.HasPrefix(item, "group ") {
+		g.group = true
+		item = strings.TrimPrefix(item, "group ")
+	}
+
+	privileges := func(rest string) string {
+		if end := strings.Index(rest, "/"); end >= 0 {
+			return rest[:end]
+		}
+		return rest
+	}
+
+	if !strings.HasPrefix(item, `"`) {
+		end := strings.Index(item, "=")
+		if end < 0 {
+			return g, "", false
+		}
+		g.name = item[:end]
+		return g, privileges(item[end+1:]), true
+	}
+
+	// A quoted name ends at a single double quote, doubled ones are part of it
+	var name strings.Builder
+	for i := 1; i < len(item); i++ {
+		if item[i] != '"' {
+			name.WriteByte(item[i])
+			continue
+		}
+		if i+1 < len(item) && item[i+1] == '"' {
+			name.WriteByte('"')
+			i++
+			continue
+		}
+		if i+1 < len(item) && item[i+1] == '=' {
+			g.name = name.String()
+			return g, privileges(item[i+2:]), true
+		}
+		return g, "", false
+	}
+	return g, "", false
+}
+
+// aclNames reports whether any item of acl grants something to g
+func aclNames(acl []string, g grantee) bool {
+	for _, item := range acl {
+		if itemGrantee, ok := aclGrantee(item); ok && itemGrantee == g {
+			return true
+		}
+	}
+	return false
+}
+
+// aclPrivileges returns the privileges the items of acl grant to g, such as arwdx
+func aclPrivileges(acl []string, g grantee) string {
+	var privileges string
+	for _, item := range acl {
+		if itemGrantee, itemPrivileges, ok := parseACLItem(item); ok && itemGrantee == g {
+			privileges += itemPrivileges
+		}
+	}
+	return privileges
+}
+
+// privilegeExecer runs the queries and statements of the teardown of one database
+type privilegeExecer interface {
+	Queryer
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// revokeAllPrivileges revokes everything granted to g in every database of the cluster, including
+// the default privileges other users give it. tx is a transaction in database, the database the
+// resource is managed from, which is where cluster wide grants are revoked. The other databases
+// are torn down in transactions of their own.
+//
+// Redshift can not commit transactions of several databases together, so the revokes in the other
+// databases are committed before the user or group is dropped, while those in database commit
+// with the drop. When the drop fails, the grantee keeps its privileges in database but has lost
+// those of the other databases. They are not granted back: the grantee is being deleted, and
+// applying again revokes what is left and drops it.
+func (c *Client) revokeAllPrivileges(ctx context.Context, tx *sql.Tx, database string, g grantee) ([]revokedPrivilege, error) {
+
+	var revoked []revokedPrivilege
+	var revoke revokeFunc = func(q privilegeExecer, database string, object string, statement string) error {
+		if _, err := q.ExecContext(ctx, statement); err != nil {
+			return wrapError(fmt.Sprintf("revoke privileges on %s in database %s of", object, database), g.String(), err)
+		}
+		r := revokedPrivilege{database: database, object: object, statement: statement}
+		log.Printf("[INFO] Revoked the privileges of %s on %s", g, r)
+		revoked = append(revoked, r)
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT datname, datacl FROM pg_database_info ORDER BY datname")
+	if err != nil {
+		return nil, wrapError("list databases to revoke privileges of", g.String(), err)
+	}
+	var databases, databaseGrants []string
+	for rows.Next() {
+		var (
+			datname string
+			datacl  pq.StringArray
+		)
+		if err := rows.Scan(&datname, &datacl); err != nil {
+			rows.Close()
+			return nil, wrapError("list databases to revoke privileges of", g.String(), err)
+		}
+		if teardownSkippedDatabases[datname] {
+			continue
+		}
+		databases = append(databases, datname)
+		if aclNames(datacl, g) {
+			databaseGrants = append(databaseGrants, datname)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("list databases to revoke privileges of", g.String(), err)
+	}
+
+	// Statements can only run in a transaction once its rows are closed
+	for _, datname := range databaseGrants {
+		statement := sqlbuilder.New("REVOKE ALL ON DATABASE").Ident(datname).Keyword("FROM", g.clause()).String()
+		if err := revoke(tx, database, "database "+datname, statement); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := c.getConnection(database)
+	if err != nil {
+		return nil, err
+	}
+	if capabilities := c.clusterCapabilities(ctx, db); capabilities != nil && capabilities.features[featureDatashares] {
+		if err := revokeDatasharePrivileges(ctx, tx, database, g, revoke); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, datname := range databases {
+
+		if datname == database {
+			if err := revokeDatabasePrivileges(ctx, tx, datname, g, revoke); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		db, err := c.getConnection(datname)
+		if err != nil {
+			return nil, err
+		}
+		err = c.withTransaction(ctx, db, g.String(), func(other *sql.Tx) error {
+			return revokeDatabasePrivileges(ctx, other, datname, g, revoke)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return revoked, nil
+}
+
+// revokeFunc runs a REVOKE statement in database and records it
+type revokeFunc func(q privilegeExecer, database string, object string, statement string) error
+
+// revokeDatabasePrivileges revokes what is granted to g on the schemas, tables, views, functions
+// and procedures of one database, and the default privileges given to it there. pg_proc_info tells
+// stored procedures, which are revoked ON PROCEDURE, from functions by prokind.
+func revokeDatabasePrivileges(ctx context.Context, q privilegeExecer, database string, g grantee, revoke revokeFunc) error {
+
+	type grant struct {
+		object    string
+		statement string
+	}
+	var grants []grant
+
+	// Each query returns the object, named as it is in statements, and its ACL
+	queries := []struct {
+		query  string
+		revoke func(name string) string
+		object func(name string) string
+	}{
+		{
+			`SELECT QUOTE_IDENT(nspname), nspacl FROM pg_namespace WHERE nspacl IS NOT NULL ORDER BY nspname`,
+			func(name string) string { return "REVOKE ALL ON SCHEMA " + name + " FROM " + g.clause() },
+			func(name string) string { return "schema " + name },
+		},
+		{
+			`SELECT QUOTE_IDENT(n.nspname) || '.' || QUOTE_IDENT(c.relname), c.relacl
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'v') AND c.relacl IS NOT NULL AND n.nspname NOT ILIKE 'pg\_temp\_%'
+			ORDER BY n.nspname, c.relname`,
+			func(name string) string { return "REVOKE ALL ON TABLE " + name + " FROM " + g.clause() },
+			func(name string) string { return "table " + name },
+		},
+		{
+			`SELECT QUOTE_IDENT(n.nspname) || '.' || QUOTE_IDENT(p.proname) || '(' || oidvectortypes(p.proargtypes) || ')', p.proacl
+			FROM pg_proc_info p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE p.proacl IS NOT NULL AND p.prokind <> 'p'
+			ORDER BY n.nspname, p.proname`,
+			func(name string) string { return "REVOKE ALL ON FUNCTION " + name + " FROM " + g.clause() },
+			func(name string) string { return "function " + name },
+		},
+		{
+			`SELECT QUOTE_IDENT(n.nspname) || '.' || QUOTE_IDENT(p.proname) || '(' || oidvectortypes(p.proargtypes) || ')', p.proacl
+			FROM pg_proc_info p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE p.proacl IS NOT NULL AND p.prokind = 'p'
+			ORDER BY n.nspname, p.proname`,
+			func(name string) string { return "REVOKE ALL ON PROCEDURE " + name + " FROM " + g.clause() },
+			func(name string) string { return "procedure " + name },
+		},
+	}
+
+	for _, query := range queries {
+		rows, err := q.QueryContext(ctx, query.query)
+		if err != nil {
+			return wrapError("find privileges in database "+database+" of", g.String(), err)
+		}
+		for rows.Next() {
+			var (
+				name string
+				acl  pq.StringArray
+			)
+			if err := rows.Scan(&name, &acl); err != nil {
+				rows.Close()
+				return wrapError("find privileges in database "+database+" of", g.String(), err)
+			}
+			if aclNames(acl, g) {
+				grants = append(grants, grant{object: query.object(name), statement: query.revoke(name)})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return wrapError("find privileges in database "+database+" of", g.String(), err)
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT u.usename, n.nspname, d.defaclobjtype, d.defaclacl
+		FROM pg_default_acl d JOIN pg_user u ON u.usesysid = d.defacluser
+		LEFT JOIN pg_namespace n ON n.oid = d.defaclnamespace
+		ORDER BY u.usename, n.nspname, d.defaclobjtype`)
+	if err != nil {
+		return wrapError("find default privileges in database "+database+" of", g.String(), err)
+	}
+	for rows.Next() {
+		var (
+			owner      string
+			schemaName sql.NullString
+			objectType string
+			acl        pq.StringArray
+		)
+		if err := rows.Scan(&owner, &schemaName, &objectType, &acl); err != nil {
+			rows.Close()
+			return wrapError("find default privileges in database "+database+" of", g.String(), err)
+		}
+		objects, ok := defaultPrivilegeObjects[objectType]
+		if !ok || !aclNames(acl, g) {
+			continue
+		}
+
+		statement := sqlbuilder.New("ALTER DEFAULT PRIVILEGES FOR USER").Ident(owner)
+		object := "default privileges on " + strings.ToLower(objects) + " of " + owner
+		if schemaName.Valid {
+			statement.Keyword("IN SCHEMA").Ident(schemaName.String)
+			object += " in schema " + schemaName.String
+		}
+		statement.Keyword("REVOKE ALL ON", objects, "FROM", g.clause())
+		grants = append(grants, grant{object: object, statement: statement.String()})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return wrapError("find default privileges in database "+database+" of", g.String(), err)
+	}
+
+	for _, grant := range grants {
+		if err := revoke(q, database, grant.object, grant.statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeDatasharePrivileges revokes the ALTER and SHARE privileges g has on datashares
+func revokeDatasharePrivileges(ctx context.Context, q privilegeExecer, database string, g grantee, revoke revokeFunc) error {
+
+	identityType := "user"
+	if g.group {
+		identityType = "group"
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT datashare_name, privilege_type FROM svv_datashare_privileges
+		WHERE identity_type = $1 AND identity_name = $2 ORDER BY datashare_name, privilege_type`, identityType, g.name)
+	if err != nil {
+		return wrapError("find datashare privileges of", g.String(), err)
+	}
+
+	type grant struct {
+		datashare string
+		privilege string
+	}
+	var grants []grant
+	for rows.Next() {
+		var r grant
+		if err := rows.Scan(&r.datashare, &r.privilege); err != nil {
+			rows.Close()
+			return wrapError("find datashare privileges of", g.String(), err)
+		}
+		grants = append(grants, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return wrapError("find datashare privileges of", g.String(), err)
+	}
+
+	for _, r := range grants {
+		privilege := strings.ToUpper(r.privilege)
+		if privilege != "ALTER" && privilege != "SHARE" {
+			continue
+		}
+		statement := sqlbuilder.New("REVOKE", privilege, "ON DATASHARE").Ident(r.datashare).Keyword("FROM", g.clause()).String()
+		if err := revoke(q, database, "datashare "+r.datashare, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
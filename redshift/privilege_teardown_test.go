@@ -0,0 +1,150 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestACLGrantee(t *testing.T) {
+	cases := []struct {
+		item    string
+		grantee grantee
+		ok      bool
+	}{
+		{"etl=arwdRxt/admin", grantee{name: "etl"}, true},
+		{"=r/admin", grantee{}, true},
+		{"group analysts=r/admin", grantee{name: "analysts", group: true}, true},
+		{`"IAM:alice"=r/admin`, grantee{name: "IAM:alice"}, true},
+		{`group "data ""team"" = x"=r/admin`, grantee{name: `data "team" = x`, group: true}, true},
+		{`"unterminated=r/admin`, grantee{}, false},
+		{"garbage", grantee{}, false},
+	}
+
+	for _, c := range cases {
+		g, ok := aclGrantee(c.item)
+		if ok != c.ok || (ok && g != c.grantee) {
+			t.Errorf("%q: expected %#v %v, got %#v %v", c.item, c.grantee, c.ok, g, ok)
+		}
+	}
+}
+
+func TestACLPrivileges(t *testing.T) {
+	acl := []string{`admin=arwdRxt/admin`, `group "Data-Team"=r*w/admin`, `group "Data-Team-ro"=rx/admin`, `group "Data-Team"=a/loader`}
+
+	if privileges := aclPrivileges(acl, grantee{name: "Data-Team", group: true}); privileges != "r*wa" {
+		t.Errorf("expected r*wa, got %q", privileges)
+	}
+	if privileges := aclPrivileges(acl, grantee{name: "Data-Team"}); privileges != "" {
+		t.Errorf("expected the user to have no privileges, got %q", privileges)
+	}
+}
+
+func TestRevokeAllPrivileges(t *testing.T) {
+	client, connector := cannedClientConnector(
+		cannedResult{match: "pg_database_info", columns: []string{"datname", "datacl"}, rows: [][]driver.Value{
+			{"analytics", nil},
+			{"dev", []byte(`{admin=CT/admin,etl=T/admin}`)},
+			{"template1", []byte(`{etl=T/admin}`)},
+		}},
+		cannedResult{match: "nspacl IS NOT NULL", columns: []string{"name", "acl"}, rows: [][]driver.Value{
+			{"etl", []byte(`{admin=UC/admin,etl=U/admin}`)},
+			{"public", []byte(`{admin=UC/admin,=U/admin}`)},
+		}},
+		cannedResult{match: "c.relacl", columns: []string{"name", "acl"}, rows: [][]driver.Value{
+			{"etl.orders", []byte(`{admin=arwdRxt/admin,"group etl=r/admin"}`)},
+		}},
+		cannedResult{match: "prokind <> 'p'", columns: []string{"name", "acl"}, rows: [][]driver.Value{
+			{"public.f_round(double precision)", []byte(`{admin=X/admin,etl=X/admin}`)},
+		}},
+		cannedResult{match: "prokind = 'p'", columns: []string{"name", "acl"}, rows: [][]driver.Value{
+			{"etl.sp_load(character varying)", []byte(`{admin=X/admin,etl=X/admin}`)},
+		}},
+		cannedResult{match: "pg_default_acl", columns: []string{"usename", "nspname", "defaclobjtype", "defaclacl"}, rows: [][]driver.Value{
+			{"admin", "etl", "r", []byte(`{etl=r/admin}`)},
+			{"loader", nil, "f", []byte(`{etl=X/loader}`)},
+			{"loader", nil, "r", []byte(`{"group etl=r/loader"}`)},
+		}},
+	)
+	defer client.Close()
+	client.connections["analytics"] = sql.OpenDB(connector)
+
+	teardown := func(g grantee) []revokedPrivilege {
+		connector.executed = nil
+		var revoked []revokedPrivilege
+		err := client.withTransaction(context.Background(), client.connections["dev"], g.String(), func(tx *sql.Tx) error {
+			var err error
+			revoked, err = client.revokeAllPrivileges(context.Background(), tx, "dev", g)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var statements []string
+		for _, r := range revoked {
+			statements = append(statements, r.statement)
+		}
+		if strings.Join(statements, "\n") != strings.Join(connector.executed, "\n") {
+			t.Errorf("expected the revoked privileges %q to be the executed statements %q", statements, connector.executed)
+		}
+		return revoked
+	}
+
+	revoked := teardown(grantee{name: "etl"})
+	perDatabase := []string{
+		`REVOKE ALL ON SCHEMA etl FROM "etl"`,
+		`REVOKE ALL ON FUNCTION public.f_round(double precision) FROM "etl"`,
+		`REVOKE ALL ON PROCEDURE etl.sp_load(character varying) FROM "etl"`,
+		`ALTER DEFAULT PRIVILEGES FOR USER "admin" IN SCHEMA "etl" REVOKE ALL ON TABLES FROM "etl"`,
+		`ALTER DEFAULT PRIVILEGES FOR USER "loader" REVOKE ALL ON FUNCTIONS FROM "etl"`,
+	}
+	expected := append([]string{`REVOKE ALL ON DATABASE "dev" FROM "etl"`}, append(perDatabase, perDatabase...)...)
+
+	var statements, databases []string
+	for _, r := range revoked {
+		statements = append(statements, r.statement)
+		databases = append(databases, r.database)
+	}
+	if strings.Join(statements, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, statements)
+	}
+	if expectedDatabases := "dev analytics analytics analytics analytics analytics dev dev dev dev dev"; strings.Join(databases, " ") != expectedDatabases {
+		t.Errorf("expected the databases %s, got %s", expectedDatabases, strings.Join(databases, " "))
+	}
+	if revoked[1].String() != "schema etl in database analytics" {
+		t.Errorf("unexpected description %s", revoked[1])
+	}
+
+	revoked = teardown(grantee{name: "etl", group: true})
+	expected = []string{
+		`REVOKE ALL ON TABLE etl.orders FROM GROUP "etl"`,
+		`ALTER DEFAULT PRIVILEGES FOR USER "loader" REVOKE ALL ON TABLES FROM GROUP "etl"`,
+	}
+	expected = append(expected, expected...)
+	statements = nil
+	for _, r := range revoked {
+		statements = append(statements, r.statement)
+	}
+	if strings.Join(statements, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, statements)
+	}
+}
+
+func TestRevokeAllPrivilegesFails(t *testing.T) {
+	client := cannedClient(
+		cannedResult{match: "pg_database_info", columns: []string{"datname", "datacl"}, rows: [][]driver.Value{{"dev", nil}}},
+		cannedResult{match: "nspacl IS NOT NULL", columns: []string{"name", "acl"}, rows: [][]driver.Value{{"failing", []byte(`{etl=U/admin}`)}}},
+	)
+	defer client.Close()
+
+	err := client.withTransaction(context.Background(), client.connections["dev"], "user etl", func(tx *sql.Tx) error {
+		_, err := client.revokeAllPrivileges(context.Background(), tx, "dev", grantee{name: "etl"})
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "schema failing in database dev") {
+		t.Errorf("expected the failed revoke to be reported, got %v", err)
+	}
+}
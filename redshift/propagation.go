@@ -0,0 +1,92 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	propagationMinBackoff = 100 * time.Millisecond
+	propagationMaxBackoff = 5 * time.Second
+)
+
+// waitForObject polls the catalog with query until it returns a row, which is scanned into dest.
+// Changes do not always propagate to the catalog tables instantly, so sql.ErrNoRows is retried
+// with exponential backoff until ctx is done. Any other error is returned straight away.
+func waitForObject(ctx context.Context, q Queryer, query string, args []interface{}, dest ...interface{}) error {
+
+	backoff := propagationMinBackoff
+
+	for {
+		err := q.QueryRowContext(ctx, query, args...).Scan(dest...)
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		if plan := dryRunPlanFrom(ctx); plan != nil {
+			plan.stop()
+			return errDryRunStopped
+		}
+
+		log.Printf("%v not found yet, checking again in %s", args, backoff)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Gave up waiting for %v to appear with query %s: %s", args, query, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > propagationMaxBackoff {
+			backoff = propagationMaxBackoff
+		}
+	}
+}
+
+// waitForCreated waits for the object just created with the quoted identifier name to appear in
+// the catalog. query selects it by the name the cluster stored. q must not be the transaction
+// that created the object, which sees it straight away, but a pool that polls after the commit.
+func waitForCreated(ctx context.Context, q Queryer, query string, name string, dest ...interface{}) error {
+
+	// Nothing was created in dry run mode
+	if plan := dryRunPlanFrom(ctx); plan != nil {
+		plan.stop()
+		return errDryRunStopped
+	}
+
+	stored, err := storedIdentifier(ctx, q, name)
+	if err != nil {
+		return err
+	}
+	return waitForObject(ctx, q, query, []interface{}{stored}, dest...)
+}
+
+// storedIdentifier returns name as the cluster stores it once written as a quoted identifier.
+// Unless enable_case_sensitive_identifier is on, Redshift folds even quoted identifiers to lower
+// case, so "Alice" is stored as alice.
+// https://docs.aws.amazon.com/redshift/latest/dg/r_enable_case_sensitive_identifier.html
+func storedIdentifier(ctx context.Context, q Queryer, name string) (string, error) {
+
+	var caseSensitive string
+	err := q.QueryRowContext(ctx, "SELECT current_setting('enable_case_sensitive_identifier')").Scan(&caseSensitive)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(caseSensitive) {
+	case "on", "true":
+		return name, nil
+	}
+
+	// Only ASCII letters are folded, like the parser does
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, name), nil
+}
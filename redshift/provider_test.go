@@ -1,12 +1,102 @@
 package redshift
 
 import (
-	"github.com/hashicorp/terraform/helper/schema"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 func TestProvider(t *testing.T) {
-	if err := Provider().(*schema.Provider).InternalValidate(); err != nil {
+	if err := Provider().InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 }
+
+func TestProviderConfigureWithoutDatabase(t *testing.T) {
+	provider := Provider()
+
+	// Nothing listens on the port, so configure would fail if it connected
+	diags := provider.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"url":      "127.0.0.1",
+		"port":     "1",
+		"user":     "admin",
+		"password": "Secret123",
+	}))
+	if diags.HasError() {
+		t.Fatalf("unexpected diagnostics %#v", diags)
+	}
+
+	client := provider.Meta().(*Client)
+	defer client.Close()
+	if client.capabilities != nil {
+		t.Errorf("expected the capabilities to be detected later, got %s", client.capabilities)
+	}
+}
+
+func TestProviderConfigureReportsURLConflicts(t *testing.T) {
+	raw := map[string]interface{}{
+		"url":      "postgres://127.0.0.1:1?sslmode=verify-ca",
+		"port":     "2",
+		"user":     "admin",
+		"password": "Secret123",
+	}
+
+	provider := Provider()
+	diags := provider.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if diags.HasError() || len(diags) != 1 || diags[0].Severity != diag.Warning {
+		t.Fatalf("expected a warning about the port, got %#v", diags)
+	}
+	provider.Meta().(*Client).Close()
+
+	raw["sslmode"] = "disable"
+	diags = Provider().Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if !diags.HasError() {
+		t.Errorf("expected sslmode disable to be rejected, got %#v", diags)
+	}
+}
+
+func TestProviderConfigureClosesAuditLogOnError(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("open files cannot be listed on this platform")
+	}
+
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	auditLogPath := filepath.Join(dir, "audit.log")
+
+	// Writing the inline certificate fails after the audit log was opened
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", filepath.Join(dir, "missing"))
+
+	ca := issueCertificate(t, nil, "Test CA")
+	diags := Provider().Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"url":            "127.0.0.1",
+		"port":           "1",
+		"user":           "admin",
+		"password":       "Secret123",
+		"sslmode":        "verify-ca",
+		"sslrootcert":    ca.certPEM,
+		"audit_log_path": auditLogPath,
+	}))
+	if !diags.HasError() {
+		t.Fatal("expected writing the TLS files to fail")
+	}
+
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, fd := range fds {
+		if target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); target == auditLogPath {
+			t.Fatalf("expected the audit log to be closed, it is still open as fd %s", fd.Name())
+		}
+	}
+}
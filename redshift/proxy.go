@@ -0,0 +1,204 @@
+package redshift
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxyFromEnvironment returns the proxy URL from ALL_PROXY and the hosts to bypass it for
+// from NO_PROXY, like curl. proxy.FromEnvironment caches the variables for the life of the
+// process, so they are read here instead.
+func proxyFromEnvironment() (proxyURL string, noProxy string) {
+	for _, name := range []string{"ALL_PROXY", "all_proxy"} {
+		if v := os.Getenv(name); v != "" {
+			proxyURL = v
+			break
+		}
+	}
+	for _, name := range []string{"NO_PROXY", "no_proxy"} {
+		if v := os.Getenv(name); v != "" {
+			noProxy = v
+			break
+		}
+	}
+	return proxyURL, noProxy
+}
+
+// defaultProxyTimeout bounds reaching the proxy and its handshake when no connect_timeout is set
+const defaultProxyTimeout = 30 * time.Second
+
+// newProxyDialer returns a dialer for a socks5://, socks5h:// or http:// proxy URL, with
+// credentials in the URL if the proxy needs them. hosts matching noProxy are dialed directly.
+// timeout bounds connecting to the proxy and asking it for a tunnel, zero means
+// defaultProxyTimeout.
+func newProxyDialer(proxyURL string, noProxy string, timeout time.Duration) (proxy.Dialer, error) {
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse proxy %q: %s", proxyURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Could not parse proxy %q: no host", proxyURL)
+	}
+
+	if timeout <= 0 {
+		timeout = defaultProxyTimeout
+	}
+
+	var dialer proxy.Dialer
+	switch u.Scheme {
+	case "http":
+		dialer = newHTTPConnectDialer(u, timeout)
+	case "socks5", "socks5h":
+		dialer, err = proxy.FromURL(u, &net.Dialer{Timeout: timeout})
+		if err != nil {
+			return nil, fmt.Errorf("Could not configure proxy %s: %s", u.Host, err)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %q, expected socks5, socks5h or http", u.Scheme)
+	}
+
+	if noProxy == "" {
+		return dialer, nil
+	}
+
+	perHost := proxy.NewPerHost(dialer, proxy.Direct)
+	perHost.AddFromString(noProxy)
+	return perHost, nil
+}
+
+// httpConnectDialer opens connections through an HTTP proxy with the CONNECT method
+type httpConnectDialer struct {
+	address       string
+	authorization string
+	timeout       time.Duration
+}
+
+func newHTTPConnectDialer(u *url.URL, timeout time.Duration) *httpConnectDialer {
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	d := &httpConnectDialer{address: net.JoinHostPort(u.Hostname(), port), timeout: timeout}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		d.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+	}
+	return d
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+
+	conn, err := (&net.Dialer{Timeout: d.timeout}).Dial("tcp", d.address)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to proxy %s: %s", d.address, err)
+	}
+
+	// A proxy that accepts the connection but never answers must not hang the dial
+	if err := conn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not connect to proxy %s: %s", d.address, err)
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.authorization != "" {
+		request.Header.Set("Proxy-Authorization", d.authorization)
+	}
+
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not send CONNECT to proxy %s: %s", d.address, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not read CONNECT response from proxy %s: %s", d.address, err)
+	}
+
+	// A successful response has no body, the connection is the tunnel from here on
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s refused to connect to %s: %s", d.address, address, response.Status)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not connect to proxy %s: %s", d.address, err)
+	}
+
+	// The server may already have sent data after the response headers
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// proxyPqDialer adapts a proxy.Dialer to the dialer interface of lib/pq
+type proxyPqDialer struct {
+	dialer proxy.Dialer
+}
+
+func (d *proxyPqDialer) Dial(network, address string) (net.Conn, error) {
+	return d.dialer.Dial(network, address)
+}
+
+func (d *proxyPqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return dialWithTimeout(func() (net.Conn, error) {
+		return d.dialer.Dial(network, address)
+	}, address, timeout)
+}
+
+// dialWithTimeout runs dial, giving up after timeout. A connection that is opened after giving
+// up is closed.
+func dialWithTimeout(dial func() (net.Conn, error), address string, timeout time.Duration) (net.Conn, error) {
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dial()
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("Timed out after %s dialing %s", timeout, address)
+	}
+}
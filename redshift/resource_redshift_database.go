@@ -5,27 +5,31 @@ package redshift
 //https://docs.aws.amazon.com/redshift/latest/dg/r_ALTER_DATABASE.html
 
 import (
+	"context"
 	"database/sql"
-	"github.com/hashicorp/terraform/helper/schema"
 	"log"
-	"time"
+
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func redshiftDatabase() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceRedshiftDatabaseCreate,
-		Read:   resourceRedshiftDatabaseRead,
-		Update: resourceRedshiftDatabaseUpdate,
-		Delete: resourceRedshiftDatabaseDelete,
-		Exists: resourceRedshiftDatabaseExists,
+		CreateContext: resourceRedshiftDatabaseCreate,
+		ReadContext:   resourceRedshiftDatabaseRead,
+		UpdateContext: resourceRedshiftDatabaseUpdate,
+		DeleteContext: resourceRedshiftDatabaseDelete,
 		Importer: &schema.ResourceImporter{
-			State: resourceRedshiftDatabaseImport,
+			StateContext: schema.ImportStatePassthroughContext,
 		},
+		Timeouts: resourceTimeouts(),
 
 		Schema: map[string]*schema.Schema{
-			"host_database_name": { // What database to connect to to manage this db
-				Type:     schema.TypeString,
-				Required: true,
+			"host_database_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "What database to connect to to manage this db. Defaults to the provider database",
 			},
 			"database_name": { //This isn't immutable. The datid returned should be used as the id
 				Type:     schema.TypeString,
@@ -37,108 +41,119 @@ func redshiftDatabase() *schema.Resource {
 				Computed: true,
 			},
 			"connection_limit": { //Cluster limit is 500
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "UNLIMITED",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "UNLIMITED",
+				ValidateFunc: validateConnectionLimit,
 			},
 		},
 	}
 }
 
-func resourceRedshiftDatabaseExists(d *schema.ResourceData, meta interface{}) (b bool, e error) {
-	// Exists - This is called to verify a resource still exists. It is called prior to Read,
-	// and lowers the burden of Read to be able to assume the resource exists.
-	client, dbErr := meta.(*Client).getConnection(d.Get("host_database_name").(string))
-
-	if dbErr != nil {
-		log.Print(dbErr)
-		return false, dbErr
-	}
+// resourceRedshiftDatabaseExists reports whether the database of d is still in the catalog
+func resourceRedshiftDatabaseExists(ctx context.Context, d *schema.ResourceData, client Queryer) (bool, error) {
 
 	var name string
 
-	err := client.QueryRow("SELECT datname FROM pg_database_info WHERE datid = $1", d.Id()).Scan(&name)
+	err := client.QueryRowContext(ctx, "SELECT datname FROM pg_database_info WHERE datid = $1", d.Id()).Scan(&name)
 	switch {
 	case err == sql.ErrNoRows:
 		return false, nil
 	case err != nil:
-		return false, err
+		return false, wrapError("check existence of", databaseObject(d), err)
 	}
 	return true, nil
 }
 
-func resourceRedshiftDatabaseCreate(d *schema.ResourceData, meta interface{}) error {
+func databaseObject(d *schema.ResourceData) string {
+	return "database " + d.Get("database_name").(string)
+}
+
+func resourceRedshiftDatabaseCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("host_database_name").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "host_database_name")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	var createStatement string = "create database " + d.Get("database_name").(string)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutCreate, databaseObject(d))
+	defer cancel()
+
+	createStatement := sqlbuilder.New("CREATE DATABASE").Ident(d.Get("database_name").(string))
 
 	//If no owner is specified it defaults to client user
 	if v, ok := d.GetOk("owner"); ok {
-		var usernames = GetUsersnamesForUsesysid(redshiftClient, []interface{}{v.(int)})
-		createStatement += " OWNER " + usernames[0]
+		username, err := GetUsernameForUsesysid(ctx, redshiftClient, v.(int))
+		if err != nil {
+			return diagnostics(err)
+		}
+		createStatement.Keyword("OWNER").Ident(username)
 	}
 
 	if v, ok := d.GetOk("connection_limit"); ok {
-		createStatement += " CONNECTION LIMIT " + v.(string)
+		createStatement.Keyword("CONNECTION LIMIT", v.(string))
 	}
 
-	log.Print("Create database statement: " + createStatement)
+	// CREATE DATABASE cannot run inside a transaction block, so only the statement itself is retried
+	err := meta.(*Client).withRetry(ctx, databaseObject(d), func() error {
+		_, err := redshiftClient.ExecContext(ctx, createStatement.String())
 
-	if _, err := redshiftClient.Exec(createStatement); err != nil {
-		log.Print(err)
-		return err
+		return wrapError("create", databaseObject(d), err)
+	})
+	if err != nil {
+		return diagnostics(err)
 	}
 
-	//The changes do not propagate instantly
-	time.Sleep(5 * time.Second)
-
 	var datid string
-	err := redshiftClient.QueryRow("SELECT datid FROM pg_database_info WHERE datname = $1", d.Get("database_name").(string)).Scan(&datid)
+	err = waitForCreated(ctx, redshiftClient, "SELECT datid FROM pg_database_info WHERE datname = $1", d.Get("database_name").(string), &datid)
 
 	if err != nil {
-		log.Print(err)
-		return err
+		return diagnostics(wrapError("find created", databaseObject(d), err))
 	}
 
 	d.SetId(datid)
 
-	readErr := readRedshiftDatabase(d, redshiftClient)
+	readErr := readRedshiftDatabase(ctx, d, redshiftClient)
 
-	return readErr
+	return diagnostics(readErr)
 }
 
-func resourceRedshiftDatabaseRead(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftDatabaseRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("host_database_name").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "host_database_name")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	err := readRedshiftDatabase(d, redshiftClient)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutRead, databaseObject(d))
+	defer cancel()
+
+	exists, err := resourceRedshiftDatabaseExists(ctx, d, redshiftClient)
+	if err != nil {
+		return diagnostics(err)
+	}
+	if !exists {
+		log.Printf("[WARN] %s no longer exists, removing it from the state", databaseObject(d))
+		d.SetId("")
+		return nil
+	}
 
-	return err
+	return diagnostics(readRedshiftDatabase(ctx, d, redshiftClient))
 }
 
-func readRedshiftDatabase(d *schema.ResourceData, db *sql.DB) error {
+func readRedshiftDatabase(ctx context.Context, d *schema.ResourceData, db Queryer) error {
 	var (
 		databasename string
 		owner        int
 		connlimit    sql.NullString
 	)
 
-	err := db.QueryRow("select datname, datdba, datconnlimit from pg_database_info where datid = $1", d.Id()).Scan(&databasename, &owner, &connlimit)
+	err := db.QueryRowContext(ctx, "select datname, datdba, datconnlimit from pg_database_info where datid = $1", d.Id()).Scan(&databasename, &owner, &connlimit)
 
 	if err != nil {
-		log.Print(err)
-		return err
+		return wrapError("read", databaseObject(d), err)
 	}
 
 	d.Set("database_name", databasename)
@@ -153,78 +168,78 @@ func readRedshiftDatabase(d *schema.ResourceData, db *sql.DB) error {
 	return nil
 }
 
-func resourceRedshiftDatabaseUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftDatabaseUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("host_database_name").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "host_database_name")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
-	tx, txErr := redshiftClient.Begin()
-	if txErr != nil {
-		panic(txErr)
-	}
-
-	if d.HasChange("database_name") {
 
-		oldName, newName := d.GetChange("database_name")
-		alterDatabaseNameQuery := "ALTER DATABASE " + oldName.(string) + " rename to " + newName.(string)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutUpdate, databaseObject(d))
+	defer cancel()
 
-		if _, err := tx.Exec(alterDatabaseNameQuery); err != nil {
-			return err
+	if d.HasChange("database_name") {
+		oldName, _ := d.GetChange("database_name")
+		if err := meta.(*Client).closeConnection(oldName.(string)); err != nil {
+			log.Printf("[WARN] Could not close the connections to database %s: %s", oldName.(string), err)
 		}
 	}
 
-	if d.HasChange("owner") {
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, databaseObject(d), func(tx *sql.Tx) error {
 
-		var username = GetUsersnamesForUsesysid(redshiftClient, []interface{}{d.Get("owner").(int)})
+		if d.HasChange("database_name") {
 
-		if _, err := tx.Exec("ALTER DATABASE " + d.Get("database_name").(string) + " OWNER TO " + username[0]); err != nil {
-			return err
-		}
-	}
+			oldName, newName := d.GetChange("database_name")
+			alterDatabaseNameQuery := sqlbuilder.New("ALTER DATABASE").Ident(oldName.(string)).Keyword("RENAME TO").Ident(newName.(string)).String()
 
-	//TODO What if value is removed?
-	if d.HasChange("connection_limit") {
-		if _, err := tx.Exec("ALTER DATABASE " + d.Get("database_name").(string) + " CONNECTION LIMIT " + d.Get("connection_limit").(string)); err != nil {
-			return err
+			if _, err := tx.ExecContext(ctx, alterDatabaseNameQuery); err != nil {
+				return wrapError("rename", "database "+oldName.(string), err)
+			}
 		}
-	}
 
-	err := readRedshiftDatabase(d, redshiftClient)
+		if d.HasChange("owner") {
 
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+			username, err := GetUsernameForUsesysid(ctx, tx, d.Get("owner").(int))
+			if err != nil {
+				return err
+			}
 
-	tx.Commit()
-	return nil
+			if _, err := tx.ExecContext(ctx, sqlbuilder.New("ALTER DATABASE").Ident(d.Get("database_name").(string)).Keyword("OWNER TO").Ident(username).String()); err != nil {
+				return wrapError("change owner of", databaseObject(d), err)
+			}
+		}
+
+		//TODO What if value is removed?
+		if d.HasChange("connection_limit") {
+			if _, err := tx.ExecContext(ctx, sqlbuilder.New("ALTER DATABASE").Ident(d.Get("database_name").(string)).Keyword("CONNECTION LIMIT", d.Get("connection_limit").(string)).String()); err != nil {
+				return wrapError("alter connection limit of", databaseObject(d), err)
+			}
+		}
+
+		return readRedshiftDatabase(ctx, d, tx)
+	}))
 }
 
-func resourceRedshiftDatabaseDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftDatabaseDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	client, dbErr := meta.(*Client).getConnection(d.Get("host_database_name").(string))
+	client, dbErr := meta.(*Client).getResourceConnection(d, "host_database_name")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	_, err := client.Exec("drop database " + d.Get("database_name").(string))
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutDelete, databaseObject(d))
+	defer cancel()
 
-	if err != nil {
-		log.Print(err)
-		return err
+	// Resources in the database, and the teardown of users and groups, leave sessions open in it
+	if err := meta.(*Client).closeConnection(d.Get("database_name").(string)); err != nil {
+		log.Printf("[WARN] Could not close the connections to %s: %s", databaseObject(d), err)
 	}
 
-	return nil
-}
+	return diagnostics(meta.(*Client).withRetry(ctx, databaseObject(d), func() error {
+		_, err := client.ExecContext(ctx, sqlbuilder.New("DROP DATABASE").Ident(d.Get("database_name").(string)).String())
 
-func resourceRedshiftDatabaseImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
-	if err := resourceRedshiftDatabaseRead(d, meta); err != nil {
-		return nil, err
-	}
-	return []*schema.ResourceData{d}, nil
+		return wrapError("drop", databaseObject(d), err)
+	}))
 }
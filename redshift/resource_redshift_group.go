@@ -1,13 +1,16 @@
 package redshift
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"github.com/hashicorp/terraform/helper/schema"
 	"log"
 	"strconv"
 	"strings"
-	"time"
+
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 // https://docs.aws.amazon.com/redshift/latest/dg/r_CREATE_GROUP.html
@@ -16,19 +19,20 @@ import (
 
 func redshiftGroup() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceRedshiftGroupCreate,
-		Read:   resourceRedshiftGroupRead,
-		Update: resourceRedshiftGroupUpdate,
-		Delete: resourceRedshiftGroupDelete,
-		Exists: resourceRedshiftGroupExists,
+		CreateContext: resourceRedshiftGroupCreate,
+		ReadContext:   resourceRedshiftGroupRead,
+		UpdateContext: resourceRedshiftGroupUpdate,
+		DeleteContext: resourceRedshiftGroupDelete,
 		Importer: &schema.ResourceImporter{
-			State: resourceRedshiftGroupImport,
+			StateContext: schema.ImportStatePassthroughContext,
 		},
+		Timeouts: resourceTimeouts(),
 
 		Schema: map[string]*schema.Schema{
 			"database": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Database to connect to. Groups are cluster wide, so this defaults to the provider database",
 			},
 			"group_name": { //This isn't immutable. The grosysid returned should be used as the id
 				Type:     schema.TypeString,
@@ -45,116 +49,106 @@ func redshiftGroup() *schema.Resource {
 	}
 }
 
-func resourceRedshiftGroupExists(d *schema.ResourceData, meta interface{}) (b bool, e error) {
-	// Exists - This is called to verify a resource still exists. It is called prior to Read,
-	// and lowers the burden of Read to be able to assume the resource exists.
-	client, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
-
-	if dbErr != nil {
-		log.Print(dbErr)
-		return false, dbErr
-	}
+// resourceRedshiftGroupExists reports whether the group of d is still in the catalog
+func resourceRedshiftGroupExists(ctx context.Context, d *schema.ResourceData, client Queryer) (bool, error) {
 
 	var name string
 
-	err := client.QueryRow("SELECT groname FROM pg_group WHERE grosysid = $1", d.Id()).Scan(&name)
+	err := client.QueryRowContext(ctx, "SELECT groname FROM pg_group WHERE grosysid = $1", d.Id()).Scan(&name)
 	switch {
 	case err == sql.ErrNoRows:
 		return false, nil
 	case err != nil:
-		return false, err
+		return false, wrapError("check existence of", groupObject(d), err)
 	}
 	return true, nil
 }
 
-func resourceRedshiftGroupCreate(d *schema.ResourceData, meta interface{}) error {
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+func groupObject(d *schema.ResourceData) string {
+	return "group " + d.Get("group_name").(string)
+}
+
+func resourceRedshiftGroupCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	tx, txErr := redshiftClient.Begin()
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutCreate, groupObject(d))
+	defer cancel()
 
-	var createStatement string = "create group " + d.Get("group_name").(string)
-	if v, ok := d.GetOk("users"); ok {
-		var usernames = GetUsersnamesForUsesysid(tx, v.(*schema.Set).List())
-		createStatement += " WITH USER " + strings.Join(usernames, ", ")
-	}
+	err := meta.(*Client).withTransaction(ctx, redshiftClient, groupObject(d), func(tx *sql.Tx) error {
 
-	log.Print("Create group statement: " + createStatement)
+		createStatement := sqlbuilder.New("CREATE GROUP").Ident(d.Get("group_name").(string))
+		if v, ok := d.GetOk("users"); ok && v.(*schema.Set).Len() > 0 {
+			usernames, err := GetUsersnamesForUsesysid(ctx, tx, v.(*schema.Set).List())
+			if err != nil {
+				return err
+			}
+			createStatement.Keyword("WITH USER").Ident(usernames...)
+		}
 
-	if _, err := tx.Exec(createStatement); err != nil {
-		return fmt.Errorf("Could not create redshift group: %s", err)
+		_, err := tx.ExecContext(ctx, createStatement.String())
+		return wrapError("create", groupObject(d), err)
+	})
+	if err != nil {
+		return diagnostics(err)
 	}
 
 	log.Print("Group created succesfully, reading grosyid from pg_group")
 
-	//The changes do not propagate instantly
-	time.Sleep(5 * time.Second)
-
 	var grosysid int
-	err := tx.QueryRow("SELECT grosysid FROM pg_group WHERE groname = $1", d.Get("group_name").(string)).Scan(&grosysid)
+	err = waitForCreated(ctx, redshiftClient, "SELECT grosysid FROM pg_group WHERE groname = $1", d.Get("group_name").(string), &grosysid)
 	if err != nil {
-		return fmt.Errorf("Could not get redshift group id: %s", err)
+		return diagnostics(wrapError("find created", groupObject(d), err))
 	}
 
 	log.Printf("grosysid is %s", strconv.Itoa(grosysid))
 
 	d.SetId(strconv.Itoa(grosysid))
 
-	readErr := readRedshiftGroup(d, tx)
-
-	if readErr != nil {
-		tx.Rollback()
-		return readErr
-	}
-
-	tx.Commit()
-	return nil
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, groupObject(d), func(tx *sql.Tx) error {
+		return readRedshiftGroup(ctx, d, tx)
+	}))
 }
 
-func resourceRedshiftGroupRead(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftGroupRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
-	}
-
-	tx, txErr := redshiftClient.Begin()
-
-	if txErr != nil {
-		panic(txErr)
+		return diagnostics(dbErr)
 	}
 
-	err := readRedshiftGroup(d, tx)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutRead, groupObject(d))
+	defer cancel()
 
+	exists, err := resourceRedshiftGroupExists(ctx, d, redshiftClient)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return diagnostics(err)
+	}
+	if !exists {
+		log.Printf("[WARN] %s no longer exists, removing it from the state", groupObject(d))
+		d.SetId("")
+		return nil
 	}
 
-	tx.Commit()
-	return nil
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, groupObject(d), func(tx *sql.Tx) error {
+		return readRedshiftGroup(ctx, d, tx)
+	}))
 }
 
-func readRedshiftGroup(d *schema.ResourceData, tx *sql.Tx) error {
+func readRedshiftGroup(ctx context.Context, d *schema.ResourceData, tx *sql.Tx) error {
 	var (
 		groupname string
 		users     sql.NullString
 	)
 
-	err := tx.QueryRow("SELECT groname, grolist FROM pg_group WHERE grosysid = $1", d.Id()).Scan(&groupname, &users)
+	err := tx.QueryRowContext(ctx, "SELECT groname, grolist FROM pg_group WHERE grosysid = $1", d.Id()).Scan(&groupname, &users)
 
 	if err != nil {
-		log.Print(err)
-		return err
+		return wrapError("read", groupObject(d), err)
 	}
 
 	d.Set("group_name", groupname)
@@ -162,18 +156,9 @@ func readRedshiftGroup(d *schema.ResourceData, tx *sql.Tx) error {
 	//Notes on postgres array types https://gist.github.com/adharris/4163702, eg startying with underscore _int4
 
 	if users.Valid {
-		var userIdsAsString = strings.Split(users.String[1:len(users.String)-1], ",")
-		var userIdsAsInt = []int{}
-
-		for _, i := range userIdsAsString {
-			if i == "" {
-				continue
-			}
-			j, err := strconv.Atoi(i)
-			if err != nil {
-				panic(err)
-			}
-			userIdsAsInt = append(userIdsAsInt, j)
+		userIdsAsInt, err := parseGroupUserList(users.String)
+		if err != nil {
+			return wrapError("read members of", groupObject(d), err)
 		}
 
 		d.Set("users", userIdsAsInt)
@@ -184,120 +169,125 @@ func readRedshiftGroup(d *schema.ResourceData, tx *sql.Tx) error {
 	return nil
 }
 
-func resourceRedshiftGroupUpdate(d *schema.ResourceData, meta interface{}) error {
-
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+// parseGroupUserList parses the grolist array, eg {100,101}
+func parseGroupUserList(users string) ([]int, error) {
 
-	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
-	}
-	tx, txErr := redshiftClient.Begin()
-	if txErr != nil {
-		panic(txErr)
+	if len(users) < 2 || users[0] != '{' || users[len(users)-1] != '}' {
+		return nil, fmt.Errorf("unexpected grolist value %q", users)
 	}
 
-	if d.HasChange("group_name") {
+	var userIdsAsString = strings.Split(users[1:len(users)-1], ",")
+	var userIdsAsInt = []int{}
 
-		oldName, newName := d.GetChange("group_name")
-		alterDatabaseNameQuery := "ALTER GROUP " + oldName.(string) + " RENAME TO " + newName.(string)
-
-		if _, err := tx.Exec(alterDatabaseNameQuery); err != nil {
-			return err
+	for _, i := range userIdsAsString {
+		if i == "" {
+			continue
 		}
+		j, err := strconv.Atoi(i)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected user id %q in grolist: %s", i, err)
+		}
+		userIdsAsInt = append(userIdsAsInt, j)
 	}
 
-	if d.HasChange("users") {
+	return userIdsAsInt, nil
+}
 
-		oldUserSet, newUserSet := d.GetChange("users")
+func resourceRedshiftGroupUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-		var usersRemoved = difference(oldUserSet.(*schema.Set).List(), newUserSet.(*schema.Set).List())
-		var usersAdded = difference(newUserSet.(*schema.Set).List(), oldUserSet.(*schema.Set).List())
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
-		if len(usersRemoved) > 0 {
+	if dbErr != nil {
+		return diagnostics(dbErr)
+	}
 
-			var usersRemovedAsString = GetUsersnamesForUsesysid(tx, usersRemoved)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutUpdate, groupObject(d))
+	defer cancel()
 
-			if _, err := tx.Exec("ALTER GROUP " + d.Get("group_name").(string) + " DROP USER " + strings.Join(usersRemovedAsString, ", ")); err != nil {
-				return err
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, groupObject(d), func(tx *sql.Tx) error {
+
+		if d.HasChange("group_name") {
+
+			oldName, newName := d.GetChange("group_name")
+			alterDatabaseNameQuery := sqlbuilder.New("ALTER GROUP").Ident(oldName.(string)).Keyword("RENAME TO").Ident(newName.(string)).String()
+
+			if _, err := tx.ExecContext(ctx, alterDatabaseNameQuery); err != nil {
+				return wrapError("rename", "group "+oldName.(string), err)
 			}
 		}
-		if len(usersAdded) > 0 {
 
-			var usersAddedAsString = GetUsersnamesForUsesysid(tx, usersAdded)
+		if d.HasChange("users") {
 
-			if _, err := tx.Exec("ALTER GROUP " + d.Get("group_name").(string) + " ADD USER " + strings.Join(usersAddedAsString, ", ")); err != nil {
-				return err
+			oldUserSet, newUserSet := d.GetChange("users")
+
+			var usersRemoved = difference(oldUserSet.(*schema.Set).List(), newUserSet.(*schema.Set).List())
+			var usersAdded = difference(newUserSet.(*schema.Set).List(), oldUserSet.(*schema.Set).List())
+
+			if len(usersRemoved) > 0 {
+
+				usersRemovedAsString, err := GetUsersnamesForUsesysid(ctx, tx, usersRemoved)
+				if err != nil {
+					return err
+				}
+
+				if _, err := tx.ExecContext(ctx, sqlbuilder.New("ALTER GROUP").Ident(d.Get("group_name").(string)).Keyword("DROP USER").Ident(usersRemovedAsString...).String()); err != nil {
+					return wrapError("remove users from", groupObject(d), err)
+				}
 			}
-		}
-	}
+			if len(usersAdded) > 0 {
 
-	err := readRedshiftGroup(d, tx)
+				usersAddedAsString, err := GetUsersnamesForUsesysid(ctx, tx, usersAdded)
+				if err != nil {
+					return err
+				}
 
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+				if _, err := tx.ExecContext(ctx, sqlbuilder.New("ALTER GROUP").Ident(d.Get("group_name").(string)).Keyword("ADD USER").Ident(usersAddedAsString...).String()); err != nil {
+					return wrapError("add users to", groupObject(d), err)
+				}
+			}
+		}
 
-	tx.Commit()
-	return nil
+		return readRedshiftGroup(ctx, d, tx)
+	}))
 }
 
-func resourceRedshiftGroupDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftGroupDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	client, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	client, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	//We need to drop all privileges and default privileges
-	rows, schemasError := client.Query("select nspname from pg_namespace")
-	defer rows.Close()
-	if schemasError != nil {
-		return schemasError
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutDelete, groupObject(d))
+	defer cancel()
+
+	return diagnostics(meta.(*Client).withTransaction(ctx, client, groupObject(d), func(tx *sql.Tx) error {
 
-	for rows.Next() {
-		var schemaName string
-		err := rows.Scan(&schemaName)
+		//We need to drop all privileges and default privileges
+		database := meta.(*Client).resourceDatabase(d, "database")
+		revoked, err := meta.(*Client).revokeAllPrivileges(ctx, tx, database, grantee{name: d.Get("group_name").(string), group: true})
 		if err != nil {
-			//Im not sure how this can happen
 			return err
 		}
-		client.Exec("REVOKE ALL ON ALL TABLES IN SCHEMA " + schemaName + " FROM GROUP " + d.Get("group_name").(string))
-		client.Exec("ALTER DEFAULT PRIVILEGES IN SCHEMA " + schemaName + " REVOKE ALL ON TABLES FROM GROUP " + d.Get("group_name").(string) + " CASCADE")
-	}
-
-	_, err := client.Exec("DROP GROUP " + d.Get("group_name").(string))
-
-	if err != nil {
-		log.Print(err)
-		return err
-	}
+		log.Printf("[INFO] Revoked %d privileges of %s before dropping it", len(revoked), groupObject(d))
 
-	return nil
-}
+		_, err = tx.ExecContext(ctx, sqlbuilder.New("DROP GROUP").Ident(d.Get("group_name").(string)).String())
 
-func resourceRedshiftGroupImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
-	if err := resourceRedshiftGroupRead(d, meta); err != nil {
-		return nil, err
-	}
-	return []*schema.ResourceData{d}, nil
+		return wrapError("drop", groupObject(d), err)
+	}))
 }
 
-func GetGroupNameForGroupId(q Queryer, grosysid int) (string, error) {
+func GetGroupNameForGroupId(ctx context.Context, q Queryer, grosysid int) (string, error) {
 
 	var name string
 
-	err := q.QueryRow("SELECT groname FROM pg_group WHERE grosysid = $1", grosysid).Scan(&name)
+	err := q.QueryRowContext(ctx, "SELECT groname FROM pg_group WHERE grosysid = $1", grosysid).Scan(&name)
 	switch {
 	case err == sql.ErrNoRows:
-		//Is this a good idea?
-		return "", err
+		return "", newError("look up", fmt.Sprintf("group %d", grosysid), "group does not exist")
 	case err != nil:
-		return "", err
+		return "", wrapError("look up", fmt.Sprintf("group %d", grosysid), err)
 	}
 	return name, nil
 }
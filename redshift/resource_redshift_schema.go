@@ -1,10 +1,14 @@
 package redshift
 
 import (
+	"context"
 	"database/sql"
-	"github.com/hashicorp/terraform/helper/schema"
+	"fmt"
 	"log"
-	"time"
+
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 /*
@@ -14,20 +18,22 @@ Add database property. This will require a new connection since you can't have d
 
 func redshiftSchema() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceRedshiftSchemaCreate,
-		Read:   resourceRedshiftSchemaRead,
-		Update: resourceRedshiftSchemaUpdate,
-		Delete: resourceRedshiftSchemaDelete,
-		Exists: resourceRedshiftSchemaExists,
+		CreateContext: resourceRedshiftSchemaCreate,
+		ReadContext:   resourceRedshiftSchemaRead,
+		UpdateContext: resourceRedshiftSchemaUpdate,
+		DeleteContext: resourceRedshiftSchemaDelete,
 		Importer: &schema.ResourceImporter{
-			State: resourceRedshiftSchemaImport,
+			StateContext: schema.ImportStatePassthroughContext,
 		},
+		Timeouts: resourceTimeouts(),
 
 		Schema: map[string]*schema.Schema{
 			"database": {
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Database the schema is created in. Defaults to the provider database",
+				ForceNew:    true,
 			},
 			"schema_name": {
 				Type:        schema.TypeString,
@@ -50,102 +56,110 @@ func redshiftSchema() *schema.Resource {
 	}
 }
 
-func resourceRedshiftSchemaExists(d *schema.ResourceData, meta interface{}) (b bool, e error) {
-	// Exists - This is called to verify a resource still exists. It is called prior to Read,
-	// and lowers the burden of Read to be able to assume the resource exists.
-	client, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
-
-	if dbErr != nil {
-		log.Print(dbErr)
-		return false, dbErr
-	}
+// resourceRedshiftSchemaExists reports whether the schema of d is still in the catalog
+func resourceRedshiftSchemaExists(ctx context.Context, d *schema.ResourceData, client Queryer) (bool, error) {
 
 	var name string
 
 	var existenceQuery = "SELECT nspname FROM pg_namespace WHERE oid = $1"
 
-	log.Print("Does schema exist query: " + existenceQuery + ", " + d.Id())
-
-	err := client.QueryRow(existenceQuery, d.Id()).Scan(&name)
+	err := client.QueryRowContext(ctx, existenceQuery, d.Id()).Scan(&name)
 	switch {
 	case err == sql.ErrNoRows:
 		return false, nil
 	case err != nil:
-		return false, err
+		return false, wrapError("check existence of", schemaObject(d), err)
 	}
 	return true, nil
 }
 
-func resourceRedshiftSchemaCreate(d *schema.ResourceData, meta interface{}) error {
+func schemaObject(d *schema.ResourceData) string {
+	return "schema " + d.Get("schema_name").(string)
+}
+
+func resourceRedshiftSchemaCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	var createStatement string = "CREATE SCHEMA " + d.Get("schema_name").(string)
+	meta.(*Client).recordResourceDatabase(d, "database")
+
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutCreate, schemaObject(d))
+	defer cancel()
+
+	createStatement := sqlbuilder.New("CREATE SCHEMA").Ident(d.Get("schema_name").(string))
 
 	//If no owner is specified it defaults to client user
 	if v, ok := d.GetOk("owner"); ok {
-		var usernames = GetUsersnamesForUsesysid(redshiftClient, []interface{}{v.(int)})
-		createStatement += " AUTHORIZATION " + usernames[0]
+		username, err := GetUsernameForUsesysid(ctx, redshiftClient, v.(int))
+		if err != nil {
+			return diagnostics(err)
+		}
+		createStatement.Keyword("AUTHORIZATION").Ident(username)
 	}
 
-	log.Print("Create Schema statement: " + createStatement)
-
-	if _, err := redshiftClient.Exec(createStatement); err != nil {
-		log.Print(err)
-		return err
+	err := meta.(*Client).withTransaction(ctx, redshiftClient, schemaObject(d), func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, createStatement.String())
+		return wrapError("create", schemaObject(d), err)
+	})
+	if err != nil {
+		return diagnostics(err)
 	}
 
-	//The changes do not propagate instantly
-	time.Sleep(5 * time.Second)
-
 	var oid string
 
-	err := redshiftClient.QueryRow("SELECT oid FROM pg_namespace WHERE nspname = $1", d.Get("schema_name").(string)).Scan(&oid)
+	err = waitForCreated(ctx, redshiftClient, "SELECT oid FROM pg_namespace WHERE nspname = $1", d.Get("schema_name").(string), &oid)
 
 	if err != nil {
-		log.Print(err)
-		return err
+		return diagnostics(wrapError("find created", schemaObject(d), err))
 	}
 
 	log.Print("Created schema with oid: " + oid)
 
 	d.SetId(oid)
 
-	readErr := readRedshiftSchema(d, redshiftClient)
-
-	return readErr
+	return diagnostics(readRedshiftSchema(ctx, d, redshiftClient))
 }
 
-func resourceRedshiftSchemaRead(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	err := readRedshiftSchema(d, redshiftClient)
+	meta.(*Client).recordResourceDatabase(d, "database")
+
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutRead, schemaObject(d))
+	defer cancel()
 
-	return err
+	exists, err := resourceRedshiftSchemaExists(ctx, d, redshiftClient)
+	if err != nil {
+		return diagnostics(err)
+	}
+	if !exists {
+		log.Printf("[WARN] %s no longer exists, removing it from the state", schemaObject(d))
+		d.SetId("")
+		return nil
+	}
+
+	return diagnostics(readRedshiftSchema(ctx, d, redshiftClient))
 }
 
-func readRedshiftSchema(d *schema.ResourceData, db *sql.DB) error {
+func readRedshiftSchema(ctx context.Context, d *schema.ResourceData, db Queryer) error {
 	var (
 		schemaName string
 		owner      int
 	)
 
-	err := db.QueryRow("select nspname, nspowner from pg_namespace where oid = $1", d.Id()).Scan(&schemaName, &owner)
+	err := db.QueryRowContext(ctx, "select nspname, nspowner from pg_namespace where oid = $1", d.Id()).Scan(&schemaName, &owner)
 
 	if err != nil {
-		log.Print(err)
-		return err
+		return wrapError("read", schemaObject(d), err)
 	}
 
 	d.Set("schema_name", schemaName)
@@ -154,94 +168,80 @@ func readRedshiftSchema(d *schema.ResourceData, db *sql.DB) error {
 	return nil
 }
 
-func resourceRedshiftSchemaUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	tx, txErr := redshiftClient.Begin()
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutUpdate, schemaObject(d))
+	defer cancel()
 
-	if d.HasChange("schema_name") {
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, schemaObject(d), func(tx *sql.Tx) error {
 
-		oldName, newName := d.GetChange("schema_name")
-		alterSchemaNameQuery := "ALTER SCHEMA " + oldName.(string) + " RENAME TO " + newName.(string)
+		if d.HasChange("schema_name") {
 
-		if _, err := tx.Exec(alterSchemaNameQuery); err != nil {
-			return err
+			oldName, newName := d.GetChange("schema_name")
+			alterSchemaNameQuery := sqlbuilder.New("ALTER SCHEMA").Ident(oldName.(string)).Keyword("RENAME TO").Ident(newName.(string)).String()
+
+			if _, err := tx.ExecContext(ctx, alterSchemaNameQuery); err != nil {
+				return wrapError("rename", "schema "+oldName.(string), err)
+			}
 		}
-	}
 
-	if d.HasChange("owner") {
+		if d.HasChange("owner") {
 
-		var username = GetUsersnamesForUsesysid(redshiftClient, []interface{}{d.Get("owner").(int)})
+			username, err := GetUsernameForUsesysid(ctx, tx, d.Get("owner").(int))
+			if err != nil {
+				return err
+			}
 
-		if _, err := tx.Exec("ALTER SCHEMA " + d.Get("schema_name").(string) + " OWNER TO " + username[0]); err != nil {
-			return err
+			if _, err := tx.ExecContext(ctx, sqlbuilder.New("ALTER SCHEMA").Ident(d.Get("schema_name").(string)).Keyword("OWNER TO").Ident(username).String()); err != nil {
+				return wrapError("change owner of", schemaObject(d), err)
+			}
 		}
-	}
-
-	err := readRedshiftSchema(d, redshiftClient)
-
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
 
-	tx.Commit()
-	return nil
+		return readRedshiftSchema(ctx, d, tx)
+	}))
 }
 
-func resourceRedshiftSchemaDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	client, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	client, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	dropSchemaQuery := "DROP SCHEMA " + d.Get("schema_name").(string)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutDelete, schemaObject(d))
+	defer cancel()
 
-	if v, ok := d.GetOk("cascade_on_delete"); ok && v.(bool) {
-		dropSchemaQuery += " CASCADE "
-	}
+	dropSchemaQuery := sqlbuilder.New("DROP SCHEMA").Ident(d.Get("schema_name").(string))
 
-	_, err := client.Exec(dropSchemaQuery)
-
-	if err != nil {
-		log.Print(err)
-		return err
+	if v, ok := d.GetOk("cascade_on_delete"); ok && v.(bool) {
+		dropSchemaQuery.Keyword("CASCADE")
 	}
 
-	return nil
-}
+	return diagnostics(meta.(*Client).withTransaction(ctx, client, schemaObject(d), func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, dropSchemaQuery.String())
 
-func resourceRedshiftSchemaImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
-	if err := resourceRedshiftSchemaRead(d, meta); err != nil {
-		return nil, err
-	}
-	return []*schema.ResourceData{d}, nil
+		return wrapError("drop", schemaObject(d), err)
+	}))
 }
 
-func GetSchemaInfoForSchemaId(q Queryer, schemaId int) (string, int, error) {
+func GetSchemaInfoForSchemaId(ctx context.Context, q Queryer, schemaId int) (string, int, error) {
 
 	var name string
 	var owner int
 
-	err := q.QueryRow("SELECT nspname, nspowner FROM pg_namespace WHERE oid = $1", schemaId).Scan(&name, &owner)
+	err := q.QueryRowContext(ctx, "SELECT nspname, nspowner FROM pg_namespace WHERE oid = $1", schemaId).Scan(&name, &owner)
 	switch {
 	case err == sql.ErrNoRows:
-		//Is this a good idea?
-		return "", -1, err
+		return "", -1, newError("look up", fmt.Sprintf("schema %d", schemaId), "schema does not exist")
 	case err != nil:
-		return "", -1, err
+		return "", -1, wrapError("look up", fmt.Sprintf("schema %d", schemaId), err)
 	}
 	return name, owner, nil
 }
@@ -1,12 +1,16 @@
 package redshift
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"strings"
 
-	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/lib/pq"
 )
 
 //https://docs.aws.amazon.com/redshift/latest/dg/r_GRANT.html
@@ -17,20 +21,22 @@ TODO Id is schema_id || '_' || group_id || '_' || owner_id, not sure if that is
 */
 func redshiftSchemaDefaultUserGroupPrivilege() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceRedshiftSchemaDefaultUserGroupPrivilegeCreate,
-		Read:   resourceRedshiftSchemaDefaultUserGroupPrivilegeRead,
-		Update: resourceRedshiftSchemaDefaultUserGroupPrivilegeUpdate,
-		Delete: resourceRedshiftSchemaDefaultUserGroupPrivilegeDelete,
-		Exists: resourceRedshiftSchemaDefaultUserGroupPrivilegeExists,
+		CreateContext: resourceRedshiftSchemaDefaultUserGroupPrivilegeCreate,
+		ReadContext:   resourceRedshiftSchemaDefaultUserGroupPrivilegeRead,
+		UpdateContext: resourceRedshiftSchemaDefaultUserGroupPrivilegeUpdate,
+		DeleteContext: resourceRedshiftSchemaDefaultUserGroupPrivilegeDelete,
 		Importer: &schema.ResourceImporter{
-			State: resourceRedshiftSchemaDefaultUserGroupPrivilegeImport,
+			StateContext: schema.ImportStatePassthroughContext,
 		},
+		Timeouts: resourceTimeouts(),
 
 		Schema: map[string]*schema.Schema{
 			"database": {
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Database the schema belongs to. Defaults to the provider database",
+				ForceNew:    true,
 			},
 			"schema_id": {
 				Type:     schema.TypeInt,
@@ -76,301 +82,272 @@ func redshiftSchemaDefaultUserGroupPrivilege() *schema.Resource {
 	}
 }
 
-func resourceRedshiftSchemaDefaultUserGroupPrivilegeExists(d *schema.ResourceData, meta interface{}) (bool, error) {
-	// Exists - This is called to verify a resource still exists. It is called prior to Read,
-	// and lowers the burden of Read to be able to assume the resource exists.
-	client, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
-
-	if dbErr != nil {
-		log.Print(dbErr)
-		return false, dbErr
-	}
-
-	var privilegeId string
+// resourceRedshiftSchemaDefaultUserGroupPrivilegeExists reports whether the default privileges of d are still in the catalog
+func resourceRedshiftSchemaDefaultUserGroupPrivilegeExists(ctx context.Context, d *schema.ResourceData, client Queryer) (bool, error) {
 
-	err := client.QueryRow(`select nsp.oid || '_' || pu.grosysid || '_' || acl.defacluser as id
+	privileges, found, err := defaultGroupPrivileges(ctx, client, `select pu.groname, acl.defaclacl
 		from pg_group pu, pg_default_acl acl, pg_namespace nsp
 		where acl.defaclnamespace = nsp.oid
-		and array_to_string(acl.defaclacl, '|') LIKE '%' || 'group ' || pu.groname || '=%'
 		and nsp.oid || '_' || pu.grosysid || '_' || acl.defacluser = $1`,
-		d.Id()).Scan(&privilegeId)
+		d.Id())
+	if err != nil {
+		return false, wrapError("check existence of", defaultPrivilegeObject(d), err)
+	}
+	return found && privileges != "", nil
+}
+
+// defaultGroupPrivileges returns the privileges the default ACLs returned by query, with the
+// name of a group, grant to that group. Group names are quoted in ACLs when they need to be, so
+// ACLs are parsed rather than matched.
+func defaultGroupPrivileges(ctx context.Context, q Queryer, query string, args ...interface{}) (string, bool, error) {
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
 
-	switch {
-	case err == sql.ErrNoRows:
-		return false, nil
-	case err != nil:
-		return false, err
+	var (
+		privileges string
+		found      bool
+	)
+	for rows.Next() {
+		var (
+			groupName string
+			acl       pq.StringArray
+		)
+		if err := rows.Scan(&groupName, &acl); err != nil {
+			return "", false, err
+		}
+		found = true
+		privileges += aclPrivileges(acl, grantee{name: groupName, group: true})
 	}
-	return true, nil
+	return privileges, found, rows.Err()
 }
 
-func resourceRedshiftSchemaDefaultUserGroupPrivilegeCreate(d *schema.ResourceData, meta interface{}) error {
+func defaultPrivilegeObject(d *schema.ResourceData) string {
+	return fmt.Sprintf("default privileges of group %d for user %d on schema %d", d.Get("group_id").(int), d.Get("owner_id").(int), d.Get("schema_id").(int))
+}
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+func resourceRedshiftSchemaDefaultUserGroupPrivilegeCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	tx, txErr := redshiftClient.Begin()
+	meta.(*Client).recordResourceDatabase(d, "database")
 
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutCreate, defaultPrivilegeObject(d))
+	defer cancel()
 
 	grants := validateGrants(d)
 
 	if len(grants) == 0 {
-		tx.Rollback()
-		return NewError("Must have at least 1 privilege")
+		return diagnostics(newError("grant", defaultPrivilegeObject(d), "Must have at least 1 privilege"))
 	}
 
-	schemaName, schemaOwner, schemaErr := GetSchemaInfoForSchemaId(tx, d.Get("schema_id").(int))
-	if schemaErr != nil {
-		log.Print(schemaErr)
-		tx.Rollback()
-		return schemaErr
-	}
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, defaultPrivilegeObject(d), func(tx *sql.Tx) error {
 
-	if isSystemSchema(schemaOwner) && schemaName != "public" {
-		tx.Rollback()
-		return NewError("Privilege creation is not allowed for system schemas, schema=" + schemaName)
-	}
+		schemaName, schemaOwner, schemaErr := GetSchemaInfoForSchemaId(ctx, tx, d.Get("schema_id").(int))
+		if schemaErr != nil {
+			return schemaErr
+		}
 
-	groupName, groupErr := GetGroupNameForGroupId(tx, d.Get("group_id").(int))
-	if groupErr != nil {
-		log.Print(groupErr)
-		tx.Rollback()
-		return groupErr
-	}
+		if isSystemSchema(schemaOwner) && schemaName != "public" {
+			return newAttributeError("grant", defaultPrivilegeObject(d), "schema_id", "Privilege creation is not allowed for system schemas, schema="+schemaName)
+		}
+
+		groupName, groupErr := GetGroupNameForGroupId(ctx, tx, d.Get("group_id").(int))
+		if groupErr != nil {
+			return groupErr
+		}
 
-	if len(grants) > 0 {
-		var defaultPrivilegesStatement = "ALTER DEFAULT PRIVILEGES"
+		defaultPrivilegesStatement := sqlbuilder.New("ALTER DEFAULT PRIVILEGES")
 
 		//If no owner is specified it defaults to client user
 		if v, ok := d.GetOk("owner_id"); ok {
-			var usernames = GetUsersnamesForUsesysid(redshiftClient, []interface{}{v.(int)})
-			defaultPrivilegesStatement += " FOR USER " + usernames[0]
+			username, err := GetUsernameForUsesysid(ctx, tx, v.(int))
+			if err != nil {
+				return err
+			}
+			defaultPrivilegesStatement.Keyword("FOR USER").Ident(username)
 		}
 
-		defaultPrivilegesStatement += " IN SCHEMA " + schemaName + " GRANT " + strings.Join(grants[:], ",") + " ON TABLES TO GROUP " + groupName
-		if _, err := tx.Exec(defaultPrivilegesStatement); err != nil {
-			log.Print(err)
-			tx.Rollback()
-			return err
+		defaultPrivilegesStatement.Keyword("IN SCHEMA").Ident(schemaName).Keyword("GRANT", strings.Join(grants[:], ","), "ON TABLES TO GROUP").Ident(groupName)
+		if _, err := tx.ExecContext(ctx, defaultPrivilegesStatement.String()); err != nil {
+			return wrapError("grant", defaultPrivilegeObject(d), err)
 		}
-	}
 
-	d.SetId(fmt.Sprint(d.Get("schema_id").(int)) + "_" + fmt.Sprint(d.Get("group_id").(int)) + "_" + fmt.Sprint(d.Get("owner_id").(int)))
+		d.SetId(fmt.Sprint(d.Get("schema_id").(int)) + "_" + fmt.Sprint(d.Get("group_id").(int)) + "_" + fmt.Sprint(d.Get("owner_id").(int)))
 
-	readErr := readRedshiftSchemaDefaultUserGroupPrivilege(d, tx)
-
-	if readErr != nil {
-		tx.Rollback()
-		return readErr
-	}
-
-	tx.Commit()
-	return nil
+		return readRedshiftSchemaDefaultUserGroupPrivilege(ctx, d, tx)
+	}))
 }
 
-func resourceRedshiftSchemaDefaultUserGroupPrivilegeRead(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaDefaultUserGroupPrivilegeRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	tx, txErr := redshiftClient.Begin()
-	if txErr != nil {
-		panic(txErr)
-	}
+	meta.(*Client).recordResourceDatabase(d, "database")
 
-	err := readRedshiftSchemaDefaultUserGroupPrivilege(d, tx)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutRead, defaultPrivilegeObject(d))
+	defer cancel()
 
+	exists, err := resourceRedshiftSchemaDefaultUserGroupPrivilegeExists(ctx, d, redshiftClient)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return diagnostics(err)
+	}
+	if !exists {
+		log.Printf("[WARN] %s no longer exists, removing it from the state", defaultPrivilegeObject(d))
+		d.SetId("")
+		return nil
 	}
 
-	tx.Commit()
-	return nil
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, defaultPrivilegeObject(d), func(tx *sql.Tx) error {
+		return readRedshiftSchemaDefaultUserGroupPrivilege(ctx, d, tx)
+	}))
 }
 
-func readRedshiftSchemaDefaultUserGroupPrivilege(d *schema.ResourceData, tx *sql.Tx) error {
-	var (
-		selectPrivilege     bool
-		updatePrivilege     bool
-		insertPrivilege     bool
-		deletePrivilege     bool
-		referencesPrivilege bool
-	)
-
-	var hasPrivilegeQuery = `
-			select
-			decode(charindex('r',split_part(split_part(array_to_string(defaclacl, '|'),'group ' || pu.groname,2 ) ,'/',1)),0,0,1)  as select,
-			decode(charindex('w',split_part(split_part(array_to_string(defaclacl, '|'),'group ' || pu.groname,2 ) ,'/',1)),0,0,1)  as update,
-			decode(charindex('a',split_part(split_part(array_to_string(defaclacl, '|'),'group ' || pu.groname,2 ) ,'/',1)),0,0,1)  as insert,
-			decode(charindex('d',split_part(split_part(array_to_string(defaclacl, '|'),'group ' || pu.groname,2 ) ,'/',1)),0,0,1)  as delete,
-			decode(charindex('x',split_part(split_part(array_to_string(defaclacl, '|'),'group ' || pu.groname,2 ) ,'/',1)),0,0,1)  as references
+func readRedshiftSchemaDefaultUserGroupPrivilege(ctx context.Context, d *schema.ResourceData, tx *sql.Tx) error {
+	var privilegeQuery = `
+			select pu.groname, acl.defaclacl
 			from pg_group pu, pg_default_acl acl, pg_namespace nsp
-			where acl.defaclnamespace = nsp.oid and
-			array_to_string(acl.defaclacl, '|') LIKE '%' || 'group ' || pu.groname || '=%'
+			where acl.defaclnamespace = nsp.oid
 			and nsp.oid = $1
 			and pu.grosysid = $2
 			and acl.defacluser = $3`
 
-	privilegesError := tx.QueryRow(hasPrivilegeQuery, d.Get("schema_id").(int), d.Get("group_id").(int), d.Get("owner_id").(int)).Scan(&selectPrivilege, &updatePrivilege, &insertPrivilege, &deletePrivilege, &referencesPrivilege)
-
-	if privilegesError != nil && privilegesError != sql.ErrNoRows {
-		tx.Rollback()
-		return privilegesError
+	privileges, _, err := defaultGroupPrivileges(ctx, tx, privilegeQuery, d.Get("schema_id").(int), d.Get("group_id").(int), d.Get("owner_id").(int))
+	if err != nil {
+		return wrapError("read", defaultPrivilegeObject(d), err)
 	}
 
-	d.Set("select", selectPrivilege)
-	d.Set("insert", insertPrivilege)
-	d.Set("update", updatePrivilege)
-	d.Set("delete", deletePrivilege)
-	d.Set("references", referencesPrivilege)
+	for _, privilege := range tablePrivilegeLetters {
+		d.Set(privilege.attribute, strings.Contains(privileges, privilege.letter))
+	}
 
 	return nil
 }
 
-func resourceRedshiftSchemaDefaultUserGroupPrivilegeUpdate(d *schema.ResourceData, meta interface{}) error {
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+func resourceRedshiftSchemaDefaultUserGroupPrivilegeUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	tx, txErr := redshiftClient.Begin()
-
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutUpdate, defaultPrivilegeObject(d))
+	defer cancel()
 
 	grants := validateGrants(d)
 
 	if len(grants) == 0 {
-		tx.Rollback()
-		return NewError("Must have at least 1 privilege")
+		return diagnostics(newError("update", defaultPrivilegeObject(d), "Must have at least 1 privilege"))
 	}
 
-	schemaName, _, schemaErr := GetSchemaInfoForSchemaId(tx, d.Get("schema_id").(int))
-	if schemaErr != nil {
-		log.Print(schemaErr)
-		tx.Rollback()
-		return schemaErr
-	}
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, defaultPrivilegeObject(d), func(tx *sql.Tx) error {
 
-	groupName, groupErr := GetGroupNameForGroupId(tx, d.Get("group_id").(int))
-	if groupErr != nil {
-		log.Print(groupErr)
-		tx.Rollback()
-		return groupErr
-	}
+		schemaName, _, schemaErr := GetSchemaInfoForSchemaId(ctx, tx, d.Get("schema_id").(int))
+		if schemaErr != nil {
+			return schemaErr
+		}
 
-	var username string
+		groupName, groupErr := GetGroupNameForGroupId(ctx, tx, d.Get("group_id").(int))
+		if groupErr != nil {
+			return groupErr
+		}
 
-	//If no owner is specified it defaults to client user
-	if v, ok := d.GetOk("owner_id"); ok {
-		var usernames = GetUsersnamesForUsesysid(redshiftClient, []interface{}{v.(int)})
-		username = usernames[0]
-	}
+		var username string
 
-	//Would be much nicer to do this with zip if possible
-	if err := updateUserGroupDefaultPrivilege(tx, d, "select", "SELECT", schemaName, groupName, username); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updateUserGroupDefaultPrivilege(tx, d, "insert", "INSERT", schemaName, groupName, username); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updateUserGroupDefaultPrivilege(tx, d, "update", "UPDATE", schemaName, groupName, username); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updateUserGroupDefaultPrivilege(tx, d, "delete", "DELETE", schemaName, groupName, username); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updateUserGroupDefaultPrivilege(tx, d, "references", "REFERENCES", schemaName, groupName, username); err != nil {
-		tx.Rollback()
-		return err
-	}
+		//If no owner is specified it defaults to client user
+		if v, ok := d.GetOk("owner_id"); ok {
+			var err error
+			username, err = GetUsernameForUsesysid(ctx, tx, v.(int))
+			if err != nil {
+				return err
+			}
+		}
 
-	tx.Commit()
-	return nil
+		//Would be much nicer to do this with zip if possible
+		if err := updateUserGroupDefaultPrivilege(ctx, tx, d, "select", "SELECT", schemaName, groupName, username); err != nil {
+			return err
+		}
+		if err := updateUserGroupDefaultPrivilege(ctx, tx, d, "insert", "INSERT", schemaName, groupName, username); err != nil {
+			return err
+		}
+		if err := updateUserGroupDefaultPrivilege(ctx, tx, d, "update", "UPDATE", schemaName, groupName, username); err != nil {
+			return err
+		}
+		if err := updateUserGroupDefaultPrivilege(ctx, tx, d, "delete", "DELETE", schemaName, groupName, username); err != nil {
+			return err
+		}
+		if err := updateUserGroupDefaultPrivilege(ctx, tx, d, "references", "REFERENCES", schemaName, groupName, username); err != nil {
+			return err
+		}
+
+		return nil
+	}))
 }
 
-func resourceRedshiftSchemaDefaultUserGroupPrivilegeDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaDefaultUserGroupPrivilegeDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
-	tx, txErr := redshiftClient.Begin()
 
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutDelete, defaultPrivilegeObject(d))
+	defer cancel()
 
-	schemaName, _, schemaErr := GetSchemaInfoForSchemaId(tx, d.Get("schema_id").(int))
-	if schemaErr != nil {
-		log.Print(schemaErr)
-		tx.Rollback()
-		return schemaErr
-	}
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, defaultPrivilegeObject(d), func(tx *sql.Tx) error {
 
-	groupName, groupErr := GetGroupNameForGroupId(tx, d.Get("group_id").(int))
-	if groupErr != nil {
-		log.Print(groupErr)
-		tx.Rollback()
-		return groupErr
-	}
+		schemaName, _, schemaErr := GetSchemaInfoForSchemaId(ctx, tx, d.Get("schema_id").(int))
+		if schemaErr != nil {
+			return schemaErr
+		}
 
-	var defaultPrivilegesStatement = "ALTER DEFAULT PRIVILEGES"
+		groupName, groupErr := GetGroupNameForGroupId(ctx, tx, d.Get("group_id").(int))
+		if groupErr != nil {
+			return groupErr
+		}
 
-	if v, ok := d.GetOk("owner_id"); ok {
-		var usernames = GetUsersnamesForUsesysid(redshiftClient, []interface{}{v.(int)})
-		defaultPrivilegesStatement += " FOR USER " + usernames[0]
-	}
+		defaultPrivilegesStatement := sqlbuilder.New("ALTER DEFAULT PRIVILEGES")
 
-	if _, err := tx.Exec(defaultPrivilegesStatement + " IN SCHEMA " + schemaName + " REVOKE ALL ON TABLES FROM GROUP " + groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
+		if v, ok := d.GetOk("owner_id"); ok {
+			username, err := GetUsernameForUsesysid(ctx, tx, v.(int))
+			if err != nil {
+				return err
+			}
+			defaultPrivilegesStatement.Keyword("FOR USER").Ident(username)
+		}
 
-	tx.Commit()
-	return nil
-}
+		if _, err := tx.ExecContext(ctx, defaultPrivilegesStatement.Keyword("IN SCHEMA").Ident(schemaName).Keyword("REVOKE ALL ON TABLES FROM GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("revoke", defaultPrivilegeObject(d), err)
+		}
 
-func resourceRedshiftSchemaDefaultUserGroupPrivilegeImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
-	if err := resourceRedshiftSchemaDefaultUserGroupPrivilegeRead(d, meta); err != nil {
-		return nil, err
-	}
-	return []*schema.ResourceData{d}, nil
+		return nil
+	}))
 }
 
-func updateUserGroupDefaultPrivilege(tx *sql.Tx, d *schema.ResourceData, attribute string, privilege string, schemaName string, groupName string, userName string) error {
+func updateUserGroupDefaultPrivilege(ctx context.Context, tx *sql.Tx, d *schema.ResourceData, attribute string, privilege string, schemaName string, groupName string, userName string) error {
 	if !d.HasChange(attribute) {
 		return nil
 	}
 
 	if d.Get(attribute).(bool) {
-		if _, err := tx.Exec("ALTER DEFAULT PRIVILEGES FOR USER " + userName + " IN SCHEMA " + schemaName + " GRANT " + privilege + " ON TABLES TO GROUP " + groupName); err != nil {
-			return err
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("ALTER DEFAULT PRIVILEGES FOR USER").Ident(userName).Keyword("IN SCHEMA").Ident(schemaName).Keyword("GRANT", privilege, "ON TABLES TO GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("grant default "+privilege+" in schema "+schemaName+" to", "group "+groupName, err)
 		}
 	} else {
-		if _, err := tx.Exec("ALTER DEFAULT PRIVILEGES FOR USER " + userName + " IN SCHEMA " + schemaName + " REVOKE " + privilege + " ON TABLES FROM GROUP " + groupName); err != nil {
-			return err
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("ALTER DEFAULT PRIVILEGES FOR USER").Ident(userName).Keyword("IN SCHEMA").Ident(schemaName).Keyword("REVOKE", privilege, "ON TABLES FROM GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("revoke default "+privilege+" in schema "+schemaName+" from", "group "+groupName, err)
 		}
 	}
 	return nil
@@ -0,0 +1,43 @@
+package redshift
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestReadDefaultPrivilegeOfQuotedGroup(t *testing.T) {
+	client := cannedClient(
+		cannedResult{match: "acl.defaclacl", columns: []string{"groname", "defaclacl"}, rows: [][]driver.Value{
+			{"Data-Team", []byte(`{"group \"Data-Team-ro\"=rwadx/admin","group \"Data-Team\"=ra/admin"}`)},
+		}},
+	)
+	defer client.Close()
+
+	db, _ := client.getConnection("dev")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer tx.Rollback()
+
+	d := schema.TestResourceDataRaw(t, redshiftSchemaDefaultUserGroupPrivilege().Schema, map[string]interface{}{"schema_id": 100, "group_id": 101, "owner_id": 102})
+	d.SetId("100_101_102")
+
+	if exists, err := resourceRedshiftSchemaDefaultUserGroupPrivilegeExists(context.Background(), d, db); err != nil || !exists {
+		t.Errorf("expected the default privileges to exist, got %v %v", exists, err)
+	}
+
+	if err := readRedshiftSchemaDefaultUserGroupPrivilege(context.Background(), d, tx); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]bool{"select": true, "insert": true, "update": false, "delete": false, "references": false}
+	for attribute, value := range expected {
+		if actual := d.Get(attribute).(bool); actual != value {
+			t.Errorf("expected %s to be %v, got %v", attribute, value, actual)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package redshift
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"strings"
 
-	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/lib/pq"
 )
 
 //https://docs.aws.amazon.com/redshift/latest/dg/r_GRANT.html
@@ -17,20 +21,22 @@ TODO Id is schema_id || '_' || group_id, not sure if that is consistent for terr
 */
 func redshiftSchemaGroupPrivilege() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceRedshiftSchemaGroupPrivilegeCreate,
-		Read:   resourceRedshiftSchemaGroupPrivilegeRead,
-		Update: resourceRedshiftSchemaGroupPrivilegeUpdate,
-		Delete: resourceRedshiftSchemaGroupPrivilegeDelete,
-		Exists: resourceRedshiftSchemaGroupPrivilegeExists,
+		CreateContext: resourceRedshiftSchemaGroupPrivilegeCreate,
+		ReadContext:   resourceRedshiftSchemaGroupPrivilegeRead,
+		UpdateContext: resourceRedshiftSchemaGroupPrivilegeUpdate,
+		DeleteContext: resourceRedshiftSchemaGroupPrivilegeDelete,
 		Importer: &schema.ResourceImporter{
-			State: resourceRedshiftSchemaGroupPrivilegeImport,
+			StateContext: schema.ImportStatePassthroughContext,
 		},
+		Timeouts: resourceTimeouts(),
 
 		Schema: map[string]*schema.Schema{
 			"database": {
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Database the schema belongs to. Defaults to the provider database",
+				ForceNew:    true,
 			},
 			"schema_id": {
 				Type:     schema.TypeInt,
@@ -81,388 +87,330 @@ func redshiftSchemaGroupPrivilege() *schema.Resource {
 	}
 }
 
-func resourceRedshiftSchemaGroupPrivilegeExists(d *schema.ResourceData, meta interface{}) (bool, error) {
-	// Exists - This is called to verify a resource still exists. It is called prior to Read,
-	// and lowers the burden of Read to be able to assume the resource exists.
-	client, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
-
-	if dbErr != nil {
-		log.Print(dbErr)
-		return false, dbErr
-	}
+// resourceRedshiftSchemaGroupPrivilegeExists reports whether the privileges of d are still in the catalog
+func resourceRedshiftSchemaGroupPrivilegeExists(ctx context.Context, d *schema.ResourceData, client Queryer) (bool, error) {
 
-	var privilegeId string
+	var (
+		groupName string
+		acl       pq.StringArray
+	)
 
-	err := client.QueryRow(`select nsp.oid || '_' || pu.grosysid as id
+	// Group names are quoted in the ACL when they need to be, so it is parsed rather than matched
+	err := client.QueryRowContext(ctx, `select pu.groname, nsp.nspacl
 		from pg_group pu, pg_namespace nsp
-		where array_to_string(nsp.nspacl, '|') LIKE '%' || 'group ' || pu.groname || '=%'
-			and nsp.oid || '_' || pu.grosysid = $1`,
-		d.Id()).Scan(&privilegeId)
+		where nsp.oid || '_' || pu.grosysid = $1`,
+		d.Id()).Scan(&groupName, &acl)
 
 	switch {
 	case err == sql.ErrNoRows:
 		return false, nil
 	case err != nil:
-		return false, err
+		return false, wrapError("check existence of", schemaGroupPrivilegeObject(d), err)
 	}
-	return true, nil
+	return aclNames(acl, grantee{name: groupName, group: true}), nil
+}
+
+func schemaGroupPrivilegeObject(d *schema.ResourceData) string {
+	return fmt.Sprintf("privileges of group %d on schema %d", d.Get("group_id").(int), d.Get("schema_id").(int))
 }
 
-func resourceRedshiftSchemaGroupPrivilegeCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaGroupPrivilegeCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	tx, txErr := redshiftClient.Begin()
+	meta.(*Client).recordResourceDatabase(d, "database")
 
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutCreate, schemaGroupPrivilegeObject(d))
+	defer cancel()
 
 	grants := validateGrants(d)
 	schemaGrants := validateSchemaGrants(d)
 
 	if len(grants) == 0 && len(schemaGrants) == 0 {
-		tx.Rollback()
-		return NewError("Must have at least 1 privilege")
-	}
-
-	schemaName, schemaOwner, schemaErr := GetSchemaInfoForSchemaId(tx, d.Get("schema_id").(int))
-	if schemaErr != nil {
-		log.Print(schemaErr)
-		tx.Rollback()
-		return schemaErr
-	}
-
-	if isSystemSchema(schemaOwner) && schemaName != "public" {
-		tx.Rollback()
-		return NewError("Privilege creation is not allowed for system schemas, schema=" + schemaName)
+		return diagnostics(newError("grant", schemaGroupPrivilegeObject(d), "Must have at least 1 privilege"))
 	}
 
-	groupName, groupErr := GetGroupNameForGroupId(tx, d.Get("group_id").(int))
-	if groupErr != nil {
-		log.Print(groupErr)
-		tx.Rollback()
-		return groupErr
-	}
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, schemaGroupPrivilegeObject(d), func(tx *sql.Tx) error {
 
-	if len(grants) > 0 {
-		var grantPrivilegeStatement = "GRANT " + strings.Join(grants[:], ",") + " ON ALL TABLES IN SCHEMA " + schemaName + " TO GROUP " + groupName
+		schemaName, schemaOwner, schemaErr := GetSchemaInfoForSchemaId(ctx, tx, d.Get("schema_id").(int))
+		if schemaErr != nil {
+			return schemaErr
+		}
 
-		if _, err := tx.Exec(grantPrivilegeStatement); err != nil {
-			log.Print(err)
-			tx.Rollback()
-			return err
+		if isSystemSchema(schemaOwner) && schemaName != "public" {
+			return newAttributeError("grant", schemaGroupPrivilegeObject(d), "schema_id", "Privilege creation is not allowed for system schemas, schema="+schemaName)
 		}
-	}
 
-	if len(schemaGrants) > 0 {
-		var grantPrivilegeSchemaStatement = "GRANT " + strings.Join(schemaGrants[:], ",") + " ON SCHEMA " + schemaName + " TO GROUP " + groupName
-		if _, err := tx.Exec(grantPrivilegeSchemaStatement); err != nil {
-			log.Print(err)
-			tx.Rollback()
-			return err
+		groupName, groupErr := GetGroupNameForGroupId(ctx, tx, d.Get("group_id").(int))
+		if groupErr != nil {
+			return groupErr
 		}
-	}
 
-	d.SetId(fmt.Sprint(d.Get("schema_id").(int)) + "_" + fmt.Sprint(d.Get("group_id").(int)))
+		if len(grants) > 0 {
+			var grantPrivilegeStatement = sqlbuilder.New("GRANT", strings.Join(grants[:], ","), "ON ALL TABLES IN SCHEMA").Ident(schemaName).Keyword("TO GROUP").Ident(groupName).String()
 
-	readErr := readRedshiftSchemaGroupPrivilege(d, tx)
+			if _, err := tx.ExecContext(ctx, grantPrivilegeStatement); err != nil {
+				return wrapError("grant table privileges on schema "+schemaName+" to", "group "+groupName, err)
+			}
+		}
 
-	if readErr != nil {
-		tx.Rollback()
-		return readErr
-	}
+		if len(schemaGrants) > 0 {
+			var grantPrivilegeSchemaStatement = sqlbuilder.New("GRANT", strings.Join(schemaGrants[:], ","), "ON SCHEMA").Ident(schemaName).Keyword("TO GROUP").Ident(groupName).String()
+			if _, err := tx.ExecContext(ctx, grantPrivilegeSchemaStatement); err != nil {
+				return wrapError("grant privileges on schema "+schemaName+" to", "group "+groupName, err)
+			}
+		}
 
-	tx.Commit()
-	return nil
+		d.SetId(fmt.Sprint(d.Get("schema_id").(int)) + "_" + fmt.Sprint(d.Get("group_id").(int)))
+
+		return readRedshiftSchemaGroupPrivilege(ctx, d, tx)
+	}))
 }
 
-func resourceRedshiftSchemaGroupPrivilegeRead(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaGroupPrivilegeRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
-	}
-	tx, txErr := redshiftClient.Begin()
-	if txErr != nil {
-		panic(txErr)
+		return diagnostics(dbErr)
 	}
 
-	err := readRedshiftSchemaGroupPrivilege(d, tx)
+	meta.(*Client).recordResourceDatabase(d, "database")
+
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutRead, schemaGroupPrivilegeObject(d))
+	defer cancel()
 
+	exists, err := resourceRedshiftSchemaGroupPrivilegeExists(ctx, d, redshiftClient)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return diagnostics(err)
+	}
+	if !exists {
+		log.Printf("[WARN] %s no longer exists, removing it from the state", schemaGroupPrivilegeObject(d))
+		d.SetId("")
+		return nil
 	}
 
-	tx.Commit()
-	return nil
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, schemaGroupPrivilegeObject(d), func(tx *sql.Tx) error {
+		return readRedshiftSchemaGroupPrivilege(ctx, d, tx)
+	}))
 }
 
-func readRedshiftSchemaGroupPrivilege(d *schema.ResourceData, tx *sql.Tx) error {
+func readRedshiftSchemaGroupPrivilege(ctx context.Context, d *schema.ResourceData, tx *sql.Tx) error {
 	var (
-		usagePrivilege      bool
-		createPrivilege     bool
-		selectPrivilege     sql.NullFloat64
-		updatePrivilege     sql.NullFloat64
-		insertPrivilege     sql.NullFloat64
-		deletePrivilege     sql.NullFloat64
-		referencesPrivilege sql.NullFloat64
+		groupName string
+		schemaACL pq.StringArray
 	)
 
-	var hasSchemaPrivilegeQuery = `
-			select
-			case
-				when charindex('U',split_part(split_part(array_to_string(nspacl, '|'), 'group ' || pu.groname,2 ) ,'/',1)) > 0 then 1
-				else 0
-			end as usage,
-			case
-				when charindex('C',split_part(split_part(array_to_string(nspacl, '|'),'group ' || pu.groname,2 ) ,'/',1)) > 0 then 1
-				else 0
-			end as create
+	// Group names are quoted in ACLs when they need to be, so ACLs are parsed rather than matched
+	var schemaPrivilegeQuery = `
+			select pu.groname, nsp.nspacl
 			from pg_group pu, pg_namespace nsp
-			where array_to_string(nsp.nspacl, '|') LIKE '%' || 'group ' || pu.groname || '=%'
-			and nsp.oid = $1
+			where nsp.oid = $1
 			and pu.grosysid = $2`
 
-	schemaPrivilegesError := tx.QueryRow(hasSchemaPrivilegeQuery, d.Get("schema_id").(int), d.Get("group_id").(int)).Scan(&usagePrivilege, &createPrivilege)
+	schemaPrivilegesError := tx.QueryRowContext(ctx, schemaPrivilegeQuery, d.Get("schema_id").(int), d.Get("group_id").(int)).Scan(&groupName, &schemaACL)
 
 	if schemaPrivilegesError != nil && schemaPrivilegesError != sql.ErrNoRows {
-		tx.Rollback()
-		return schemaPrivilegesError
+		return wrapError("read schema", schemaGroupPrivilegeObject(d), schemaPrivilegesError)
 	}
 
-	d.Set("usage", usagePrivilege)
-	d.Set("create", createPrivilege)
+	group := grantee{name: groupName, group: true}
+	schemaPrivileges := aclPrivileges(schemaACL, group)
+
+	d.Set("usage", strings.Contains(schemaPrivileges, "U"))
+	d.Set("create", strings.Contains(schemaPrivileges, "C"))
 
-	var hasTablePrivilegeQuery = `
-		SELECT
-			avg(decode(charindex ('r', split_part(split_part(array_to_string(cls.relacl, '|'), 'group ' || pg.groname, 2), '/', 1)), 0, 0, 1.0)) AS "select",
-			avg(decode(charindex ('w', split_part(split_part(array_to_string(cls.relacl, '|'), 'group ' || pg.groname, 2), '/', 1)), 0, 0, 1.0)) AS "update",
-			avg(decode(charindex ('a', split_part(split_part(array_to_string(cls.relacl, '|'), 'group ' || pg.groname, 2), '/', 1)), 0, 0, 1.0)) AS "insert",
-			avg(decode(charindex ('d', split_part(split_part(array_to_string(cls.relacl, '|'), 'group ' || pg.groname, 2), '/', 1)), 0, 0, 1.0)) AS "delete",
-			avg(decode(charindex ('x', split_part(split_part(array_to_string(cls.relacl, '|'), 'group ' || pg.groname, 2), '/', 1)), 0, 0, 1.0)) AS "references"
+	var tablePrivilegeQuery = `
+		SELECT cls.relacl
 		FROM
 			pg_user use
 			LEFT JOIN pg_class cls ON cls.relowner = use.usesysid
-			CROSS JOIN pg_group pg
 		WHERE
-			cls.relnamespace = $1 AND pg.grosysid = $2 AND cls.relkind <> 'i';
+			cls.relnamespace = $1 AND cls.relkind <> 'i';
 	`
 
-	tablePrivilegesError := tx.QueryRow(hasTablePrivilegeQuery, d.Get("schema_id").(int), d.Get("group_id").(int)).Scan(&selectPrivilege, &updatePrivilege, &insertPrivilege, &deletePrivilege, &referencesPrivilege)
-
-	if tablePrivilegesError != nil && tablePrivilegesError != sql.ErrNoRows {
-		tx.Rollback()
-		return tablePrivilegesError
+	rows, err := tx.QueryContext(ctx, tablePrivilegeQuery, d.Get("schema_id").(int))
+	if err != nil {
+		return wrapError("read table", schemaGroupPrivilegeObject(d), err)
 	}
 
-	if selectPrivilege.Valid {
-		if selectPrivilege.Float64 >= 1 {
-			d.Set("select", true)
-		} else if selectPrivilege.Float64 <= 0 {
-			d.Set("select", false)
-		} else {
-			d.Set("select", !d.Get("select").(bool))
+	// How many of the tables grant each privilege to the group
+	var tables int
+	granted := map[string]int{}
+	for rows.Next() {
+		var tableACL pq.StringArray
+		if err := rows.Scan(&tableACL); err != nil {
+			rows.Close()
+			return wrapError("read table", schemaGroupPrivilegeObject(d), err)
 		}
-	}
-
-	if updatePrivilege.Valid {
-		if updatePrivilege.Float64 >= 1 {
-			d.Set("update", true)
-		} else if updatePrivilege.Float64 <= 0 {
-			d.Set("update", false)
-		} else {
-			d.Set("update", !d.Get("update").(bool))
+		tables++
+		tablePrivileges := aclPrivileges(tableACL, group)
+		for _, privilege := range tablePrivilegeLetters {
+			if strings.Contains(tablePrivileges, privilege.letter) {
+				granted[privilege.attribute]++
+			}
 		}
 	}
-
-	if insertPrivilege.Valid {
-		if insertPrivilege.Float64 >= 1 {
-			d.Set("insert", true)
-		} else if insertPrivilege.Float64 <= 0 {
-			d.Set("insert", false)
-		} else {
-			d.Set("insert", !d.Get("insert").(bool))
-		}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return wrapError("read table", schemaGroupPrivilegeObject(d), err)
 	}
 
-	if deletePrivilege.Valid {
-		if deletePrivilege.Float64 >= 1 {
-			d.Set("delete", true)
-		} else if deletePrivilege.Float64 <= 0 {
-			d.Set("delete", false)
-		} else {
-			d.Set("delete", !d.Get("delete").(bool))
-		}
+	if tables == 0 {
+		return nil
 	}
 
-	if referencesPrivilege.Valid {
-		if referencesPrivilege.Float64 >= 1 {
-			d.Set("references", true)
-		} else if referencesPrivilege.Float64 <= 0 {
-			d.Set("references", false)
-		} else {
-			d.Set("references", !d.Get("references").(bool))
+	// A privilege granted on some of the tables only is shown as a difference, so it is granted
+	// on all of them again
+	for _, privilege := range tablePrivilegeLetters {
+		switch granted[privilege.attribute] {
+		case tables:
+			d.Set(privilege.attribute, true)
+		case 0:
+			d.Set(privilege.attribute, false)
+		default:
+			d.Set(privilege.attribute, !d.Get(privilege.attribute).(bool))
 		}
 	}
 
 	return nil
 }
 
-func resourceRedshiftSchemaGroupPrivilegeUpdate(d *schema.ResourceData, meta interface{}) error {
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+// tablePrivilegeLetters are the letters of the table privileges in an ACL
+var tablePrivilegeLetters = []struct {
+	attribute string
+	letter    string
+}{
+	{"select", "r"},
+	{"update", "w"},
+	{"insert", "a"},
+	{"delete", "d"},
+	{"references", "x"},
+}
+
+func resourceRedshiftSchemaGroupPrivilegeUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
-	tx, txErr := redshiftClient.Begin()
 
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutUpdate, schemaGroupPrivilegeObject(d))
+	defer cancel()
 
 	grants := validateGrants(d)
 	schemaGrants := validateSchemaGrants(d)
 
 	if len(grants) == 0 && len(schemaGrants) == 0 {
-		tx.Rollback()
-		return NewError("Must have at least 1 privilege")
+		return diagnostics(newError("update", schemaGroupPrivilegeObject(d), "Must have at least 1 privilege"))
 	}
 
-	schemaName, _, schemaErr := GetSchemaInfoForSchemaId(tx, d.Get("schema_id").(int))
-	if schemaErr != nil {
-		log.Print(schemaErr)
-		tx.Rollback()
-		return schemaErr
-	}
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, schemaGroupPrivilegeObject(d), func(tx *sql.Tx) error {
 
-	groupName, groupErr := GetGroupNameForGroupId(tx, d.Get("group_id").(int))
-	if groupErr != nil {
-		log.Print(groupErr)
-		tx.Rollback()
-		return groupErr
-	}
+		schemaName, _, schemaErr := GetSchemaInfoForSchemaId(ctx, tx, d.Get("schema_id").(int))
+		if schemaErr != nil {
+			return schemaErr
+		}
 
-	//Would be much nicer to do this with zip if possible
-	if err := updatePrivilege(tx, d, "select", "SELECT", schemaName, groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updatePrivilege(tx, d, "insert", "INSERT", schemaName, groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updatePrivilege(tx, d, "update", "UPDATE", schemaName, groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updatePrivilege(tx, d, "delete", "DELETE", schemaName, groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updatePrivilege(tx, d, "references", "REFERENCES", schemaName, groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updateSchemaPrivilege(tx, d, "usage", "USAGE", schemaName, groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := updateSchemaPrivilege(tx, d, "create", "CREATE", schemaName, groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
+		groupName, groupErr := GetGroupNameForGroupId(ctx, tx, d.Get("group_id").(int))
+		if groupErr != nil {
+			return groupErr
+		}
 
-	tx.Commit()
-	return nil
+		//Would be much nicer to do this with zip if possible
+		if err := updatePrivilege(ctx, tx, d, "select", "SELECT", schemaName, groupName); err != nil {
+			return err
+		}
+		if err := updatePrivilege(ctx, tx, d, "insert", "INSERT", schemaName, groupName); err != nil {
+			return err
+		}
+		if err := updatePrivilege(ctx, tx, d, "update", "UPDATE", schemaName, groupName); err != nil {
+			return err
+		}
+		if err := updatePrivilege(ctx, tx, d, "delete", "DELETE", schemaName, groupName); err != nil {
+			return err
+		}
+		if err := updatePrivilege(ctx, tx, d, "references", "REFERENCES", schemaName, groupName); err != nil {
+			return err
+		}
+		if err := updateSchemaPrivilege(ctx, tx, d, "usage", "USAGE", schemaName, groupName); err != nil {
+			return err
+		}
+		if err := updateSchemaPrivilege(ctx, tx, d, "create", "CREATE", schemaName, groupName); err != nil {
+			return err
+		}
+
+		return nil
+	}))
 }
 
-func resourceRedshiftSchemaGroupPrivilegeDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftSchemaGroupPrivilegeDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
-	tx, txErr := redshiftClient.Begin()
 
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutDelete, schemaGroupPrivilegeObject(d))
+	defer cancel()
 
-	schemaName, _, schemaErr := GetSchemaInfoForSchemaId(tx, d.Get("schema_id").(int))
-	if schemaErr != nil {
-		log.Print(schemaErr)
-		tx.Rollback()
-		return schemaErr
-	}
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, schemaGroupPrivilegeObject(d), func(tx *sql.Tx) error {
 
-	groupName, groupErr := GetGroupNameForGroupId(tx, d.Get("group_id").(int))
-	if groupErr != nil {
-		log.Print(groupErr)
-		tx.Rollback()
-		return groupErr
-	}
-	if _, err := tx.Exec("REVOKE ALL ON ALL TABLES IN SCHEMA " + schemaName + " FROM GROUP " + groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
+		schemaName, _, schemaErr := GetSchemaInfoForSchemaId(ctx, tx, d.Get("schema_id").(int))
+		if schemaErr != nil {
+			return schemaErr
+		}
 
-	if _, err := tx.Exec("REVOKE ALL ON SCHEMA " + schemaName + " FROM GROUP " + groupName); err != nil {
-		tx.Rollback()
-		return err
-	}
+		groupName, groupErr := GetGroupNameForGroupId(ctx, tx, d.Get("group_id").(int))
+		if groupErr != nil {
+			return groupErr
+		}
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("REVOKE ALL ON ALL TABLES IN SCHEMA").Ident(schemaName).Keyword("FROM GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("revoke table privileges on schema "+schemaName+" from", "group "+groupName, err)
+		}
 
-	tx.Commit()
-	return nil
-}
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("REVOKE ALL ON SCHEMA").Ident(schemaName).Keyword("FROM GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("revoke privileges on schema "+schemaName+" from", "group "+groupName, err)
+		}
 
-func resourceRedshiftSchemaGroupPrivilegeImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
-	if err := resourceRedshiftSchemaGroupPrivilegeRead(d, meta); err != nil {
-		return nil, err
-	}
-	return []*schema.ResourceData{d}, nil
+		return nil
+	}))
 }
 
-func updatePrivilege(tx *sql.Tx, d *schema.ResourceData, attribute string, privilege string, schemaName string, groupName string) error {
+func updatePrivilege(ctx context.Context, tx *sql.Tx, d *schema.ResourceData, attribute string, privilege string, schemaName string, groupName string) error {
 	if !d.HasChange(attribute) {
 		return nil
 	}
 
 	if d.Get(attribute).(bool) {
-		if _, err := tx.Exec("GRANT " + privilege + " ON ALL TABLES IN SCHEMA " + schemaName + " TO GROUP " + groupName); err != nil {
-			return err
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("GRANT", privilege, "ON ALL TABLES IN SCHEMA").Ident(schemaName).Keyword("TO GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("grant "+privilege+" on tables in schema "+schemaName+" to", "group "+groupName, err)
 		}
 	} else {
-		if _, err := tx.Exec("REVOKE " + privilege + " ON ALL TABLES IN SCHEMA " + schemaName + " FROM GROUP " + groupName); err != nil {
-			return err
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("REVOKE", privilege, "ON ALL TABLES IN SCHEMA").Ident(schemaName).Keyword("FROM GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("revoke "+privilege+" on tables in schema "+schemaName+" from", "group "+groupName, err)
 		}
 	}
 	return nil
 }
 
-func updateSchemaPrivilege(tx *sql.Tx, d *schema.ResourceData, attribute string, privilege string, schemaName string, groupName string) error {
+func updateSchemaPrivilege(ctx context.Context, tx *sql.Tx, d *schema.ResourceData, attribute string, privilege string, schemaName string, groupName string) error {
 	if !d.HasChange(attribute) {
 		return nil
 	}
 
 	if d.Get(attribute).(bool) {
-		if _, err := tx.Exec("GRANT " + privilege + " ON SCHEMA " + schemaName + " TO GROUP " + groupName); err != nil {
-			return err
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("GRANT", privilege, "ON SCHEMA").Ident(schemaName).Keyword("TO GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("grant "+privilege+" on schema "+schemaName+" to", "group "+groupName, err)
 		}
 	} else {
-		if _, err := tx.Exec("REVOKE " + privilege + " ON SCHEMA " + schemaName + " FROM GROUP " + groupName); err != nil {
-			return err
+		if _, err := tx.ExecContext(ctx, sqlbuilder.New("REVOKE", privilege, "ON SCHEMA").Ident(schemaName).Keyword("FROM GROUP").Ident(groupName).String()); err != nil {
+			return wrapError("revoke "+privilege+" on schema "+schemaName+" from", "group "+groupName, err)
 		}
 	}
 	return nil
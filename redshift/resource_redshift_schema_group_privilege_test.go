@@ -0,0 +1,63 @@
+package redshift
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestReadSchemaGroupPrivilegeOfQuotedGroup(t *testing.T) {
+	client := cannedClient(
+		cannedResult{match: "nsp.nspacl", columns: []string{"groname", "nspacl"}, rows: [][]driver.Value{
+			{"Data-Team", []byte(`{admin=UC/admin,"group \"Data-Team-ro\"=UC/admin","group \"Data-Team\"=U/admin"}`)},
+		}},
+		cannedResult{match: "cls.relacl", columns: []string{"relacl"}, rows: [][]driver.Value{
+			{[]byte(`{admin=arwdRxt/admin,"group \"Data-Team\"=rw/admin"}`)},
+			{[]byte(`{admin=arwdRxt/admin,"group \"Data-Team\"=r/admin","group \"Data-Team-ro\"=x/admin"}`)},
+		}},
+	)
+	defer client.Close()
+
+	db, _ := client.getConnection("dev")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer tx.Rollback()
+
+	d := schema.TestResourceDataRaw(t, redshiftSchemaGroupPrivilege().Schema, map[string]interface{}{"schema_id": 100, "group_id": 101, "update": true})
+
+	if err := readRedshiftSchemaGroupPrivilege(context.Background(), d, tx); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]bool{"usage": true, "create": false, "select": true, "insert": false, "delete": false, "references": false}
+	for attribute, value := range expected {
+		if actual := d.Get(attribute).(bool); actual != value {
+			t.Errorf("expected %s to be %v, got %v", attribute, value, actual)
+		}
+	}
+	// update is granted on some tables only, so it is shown as a difference
+	if d.Get("update").(bool) {
+		t.Error("expected update granted on some of the tables to differ from the configuration")
+	}
+}
+
+func TestSchemaGroupPrivilegeExistsForQuotedGroup(t *testing.T) {
+	client := cannedClient(
+		cannedResult{match: "nsp.nspacl", columns: []string{"groname", "nspacl"}, rows: [][]driver.Value{
+			{"Data-Team", []byte(`{admin=UC/admin,"group \"Data-Team\"=U/admin"}`)},
+		}},
+	)
+	defer client.Close()
+
+	db, _ := client.getConnection("dev")
+	d := schema.TestResourceDataRaw(t, redshiftSchemaGroupPrivilege().Schema, map[string]interface{}{"schema_id": 100, "group_id": 101})
+	d.SetId("100_101")
+
+	if exists, err := resourceRedshiftSchemaGroupPrivilegeExists(context.Background(), d, db); err != nil || !exists {
+		t.Errorf("expected the privileges to exist, got %v %v", exists, err)
+	}
+}
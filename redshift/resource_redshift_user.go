@@ -1,43 +1,119 @@
 package redshift
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/lib/pq"
 )
 
 func redshiftUser() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceRedshiftUserCreate,
-		Read:   resourceRedshiftUserRead,
-		Update: resourceRedshiftUserUpdate,
-		Delete: resourceRedshiftUserDelete,
-		Exists: resourceRedshiftUserExists,
+		CreateContext: resourceRedshiftUserCreate,
+		ReadContext:   resourceRedshiftUserRead,
+		UpdateContext: resourceRedshiftUserUpdate,
+		DeleteContext: resourceRedshiftUserDelete,
 		Importer: &schema.ResourceImporter{
-			State: resourceRedshiftUserImport,
+			StateContext: schema.ImportStatePassthroughContext,
 		},
+		Timeouts:      resourceTimeouts(),
+		CustomizeDiff: resourceRedshiftUserCustomizeDiff,
 
 		Schema: map[string]*schema.Schema{
 			"database": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Database to connect to. Users are cluster wide, so this defaults to the provider database",
 			},
 			"username": { //This isn't immutable. The usesysid returned should be used as the id
 				Type:     schema.TypeString,
 				Required: true,
 			},
-			"password": { //Can we read this back from the db? If not hwo can we tell if its changed? Do we need to use md5hash?
+			"password": { //Only superusers can read the stored md5 hash to tell if it has changed
 				Type:      schema.TypeString,
 				Optional:  true,
 				Sensitive: true,
 			},
-			"valid_until": {
+			"password_hash_method": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      passwordHashNone,
+				Description:  "Hash the password before sending it to the cluster, with md5 or salted sha256, rather than sending it in plain text (none)",
+				ValidateFunc: validation.StringInSlice([]string{passwordHashNone, passwordHashMD5, passwordHashSHA256}, false),
+			},
+			"generate_password": {
+				Type:          schema.TypeBool,
+				Optional:      true,
+				Default:       false,
+				Description:   "Generate a random password, which is kept in state encrypted with pgp_key, or in generated_password with store_plaintext_password",
+				ConflictsWith: []string{"password"},
+			},
+			"password_length": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      32,
+				Description:  "Length of the generated password",
+				ValidateFunc: validation.IntBetween(8, 64),
+			},
+			"pgp_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Base64 encoded or armored PGP public key, or keybase:username, the generated password is encrypted with",
+			},
+			"store_plaintext_password": {
+				Type:          schema.TypeBool,
+				Optional:      true,
+				Default:       false,
+				Description:   "Keep the generated password unencrypted in generated_password, and so in plain text in the state, instead of encrypting it with pgp_key",
+				ConflictsWith: []string{"pgp_key"},
+			},
+			"generated_password": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Sensitive:   true,
+				Description: "Generated password, when store_plaintext_password is set",
+			},
+			"rotation_days": {
+				Type:          schema.TypeInt,
+				Optional:      true,
+				Description:   "Generate a new password once the current one is this many days old, which is also when it expires",
+				ValidateFunc:  validation.IntAtLeast(0),
+				ConflictsWith: []string{"valid_until"},
+			},
+			"keepers": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Arbitrary values which generate a new password when they change",
+			},
+			"last_rotated_at": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "When the password was last generated, in RFC 3339 format",
+			},
+			"encrypted_password": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Generated password encrypted with pgp_key, base64 encoded",
+			},
+			"key_fingerprint": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Fingerprint of the PGP key the generated password is encrypted with",
+			},
+			"valid_until": { //Computed as it is set from rotation_days
 				Type:     schema.TypeString,
 				Optional: true,
+				Computed: true,
 			},
 			"password_disabled": {
 				Type:     schema.TypeBool,
@@ -50,20 +126,52 @@ func redshiftUser() *schema.Resource {
 				Default:  false,
 			},
 			"connection_limit": { //Cluster limit is 500 anyway
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "UNLIMITED",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "UNLIMITED",
+				ValidateFunc: validateConnectionLimit,
 			},
-			"syslog_access": { //Can be RESTRICTED | UNRESTRICTED
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "RESTRICTED",
+			"syslog_access": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "RESTRICTED",
+				ValidateFunc: validation.StringInSlice([]string{"RESTRICTED", "UNRESTRICTED"}, false),
+			},
+			"session_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "Seconds a session can be idle before it is closed, 0 for the cluster timeout",
+				ValidateFunc: validateSessionTimeout,
+			},
+			"external_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Identifier of the user in the identity provider it was created from",
 			},
 			"superuser": { //If true set CREATEUSER
 				Type:     schema.TypeBool,
 				Optional: true,
 				Default:  false,
 			},
+			"session_parameters": {
+				Type:         schema.TypeMap,
+				Optional:     true,
+				Elem:         &schema.Schema{Type: schema.TypeString},
+				Description:  "Session defaults of configuration parameters, such as search_path or statement_timeout",
+				ValidateFunc: validateSessionParameters,
+			},
+			"reassign_owned_to": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "User id or name the objects owned by the user are given to when it is deleted, defaults to the user the provider connects as",
+				ConflictsWith: []string{"fail_on_owned_objects"},
+			},
+			"fail_on_owned_objects": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Fail to delete the user, listing the objects it owns, rather than reassigning them",
+			},
 			"usesysid": {
 				Type:     schema.TypeString,
 				Computed: true,
@@ -72,159 +180,187 @@ func redshiftUser() *schema.Resource {
 	}
 }
 
-func resourceRedshiftUserExists(d *schema.ResourceData, meta interface{}) (b bool, e error) {
-	// Exists - This is called to verify a resource still exists. It is called prior to Read,
-	// and lowers the burden of Read to be able to assume the resource exists.
-	client, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+// resourceRedshiftUserExists reports whether the user of d is still in the catalog
+func resourceRedshiftUserExists(ctx context.Context, d *schema.ResourceData, client Queryer) (bool, error) {
 
-	if dbErr != nil {
-		log.Print(dbErr)
-		return false, dbErr
-	}
 	var name string
 
-	err := client.QueryRow("SELECT usename FROM pg_user_info WHERE usesysid = $1", d.Id()).Scan(&name)
+	err := client.QueryRowContext(ctx, "SELECT usename FROM pg_user_info WHERE usesysid = $1", d.Id()).Scan(&name)
 	switch {
 	case err == sql.ErrNoRows:
 		return false, nil
 	case err != nil:
-		return false, err
+		return false, wrapError("check existence of", userObject(d), err)
 	}
 	return true, nil
 }
 
-func resourceRedshiftUserCreate(d *schema.ResourceData, meta interface{}) error {
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+func userObject(d *schema.ResourceData) string {
+	return "user " + d.Get("username").(string)
+}
+
+func resourceRedshiftUserCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
+	}
+
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutCreate, userObject(d))
+	defer cancel()
+
+	password := d.Get("password").(string)
+	if d.Get("generate_password").(bool) {
+		var err error
+		if password, err = generateUserPassword(d); err != nil {
+			return diagnostics(err)
+		}
+	}
+
+	createStatement, err := createUserStatement(d, password)
+	if err != nil {
+		return diagnostics(err)
 	}
 
-	tx, txErr := redshiftClient.Begin()
+	err = meta.(*Client).withTransaction(ctx, redshiftClient, userObject(d), func(tx *sql.Tx) error {
+
+		if _, err := tx.ExecContext(ctx, createStatement); err != nil {
+			return wrapError("create", userObject(d), err)
+		}
 
-	if txErr != nil {
-		panic(txErr)
+		return updateSessionParameters(ctx, d, tx)
+	})
+	if err != nil {
+		return diagnostics(err)
 	}
 
-	var createStatement string = "create user " + d.Get("username").(string) + " with password "
+	// Only other sessions can see the new user appear once the transaction is committed
+	log.Print("User created, waiting for it to appear in pg_user_info")
+
+	var usesysid string
+	err = waitForCreated(ctx, redshiftClient, "SELECT usesysid FROM pg_user_info WHERE usename = $1", d.Get("username").(string), &usesysid)
+
+	if err != nil {
+		return diagnostics(wrapError("find created", userObject(d), err))
+	}
+
+	log.Printf("usesysid for user is %s", usesysid)
+
+	d.SetId(usesysid)
+
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, userObject(d), func(tx *sql.Tx) error {
+		return readRedshiftUser(ctx, d, tx)
+	}))
+}
+
+// createUserStatement returns the CREATE USER statement setting password, which is the
+// configured or generated password
+func createUserStatement(d *schema.ResourceData, password string) (string, error) {
+
+	createStatement := sqlbuilder.New("CREATE USER").Ident(d.Get("username").(string)).Keyword("WITH PASSWORD")
 
 	if v, ok := d.GetOk("password_disabled"); ok && v.(bool) {
-		createStatement += " DISABLE "
-	} else if v, ok := d.GetOk("password"); ok {
-		createStatement += "'" + v.(string) + "' "
+		createStatement.Keyword("DISABLE")
+	} else if password != "" {
+		hash, err := hashPassword(d.Get("password_hash_method").(string), password, d.Get("username").(string))
+		if err != nil {
+			return "", wrapError("create", userObject(d), err)
+		}
+		createStatement.Literal(hash)
 	} else {
-		return fmt.Errorf("Either password_disabled attribute has to be set to true or password attribute has to be provided")
+		return "", newAttributeError("create", userObject(d), "password", "Either password_disabled attribute has to be set to true or password attribute has to be provided")
 	}
 
 	if v, ok := d.GetOk("valid_until"); ok {
 		//TODO Validate v is in format YYYY-mm-dd
-		createStatement += "VALID UNTIL '" + v.(string) + "'"
+		createStatement.Keyword("VALID UNTIL").Literal(v.(string))
 	}
 	if v, ok := d.GetOk("createdb"); ok {
 		if v.(bool) {
-			createStatement += " CREATEDB "
+			createStatement.Keyword("CREATEDB")
 		} else {
-			createStatement += " NOCREATEDB "
+			createStatement.Keyword("NOCREATEDB")
 		}
 	}
 	if v, ok := d.GetOk("connection_limit"); ok {
-		createStatement += " CONNECTION LIMIT " + v.(string)
+		createStatement.Keyword("CONNECTION LIMIT", v.(string))
 	}
 	if v, ok := d.GetOk("syslog_access"); ok {
 		if v.(string) == "UNRESTRICTED" {
-			createStatement += " SYSLOG ACCESS UNRESTRICTED "
+			createStatement.Keyword("SYSLOG ACCESS UNRESTRICTED")
 		} else if v.(string) == "RESTRICTED" {
-			createStatement += " SYSLOG ACCESS RESTRICTED "
+			createStatement.Keyword("SYSLOG ACCESS RESTRICTED")
 		} else {
-			log.Printf("%v is not a valid value for SYSLOG ACCESS", v)
-			panic(v.(string))
+			return "", newAttributeError("create", userObject(d), "syslog_access", fmt.Sprintf("%v is not a valid value for SYSLOG ACCESS", v))
 		}
 	}
 	if v, ok := d.GetOk("superuser"); ok && v.(bool) {
-		createStatement += " CREATEUSER "
-	}
-
-	if _, err := tx.Exec(createStatement); err != nil {
-		return fmt.Errorf("Could not create redshift user: %s", err)
-	}
-
-	log.Print("User created, waiting 5 seconds for propagation")
-
-	//The changes do not propagate instantly
-	time.Sleep(5 * time.Second)
-
-	var usesysid string
-	err := tx.QueryRow("SELECT usesysid FROM pg_user_info WHERE usename = $1", d.Get("username").(string)).Scan(&usesysid)
-
-	if err != nil {
-		log.Print("User does not exist in pg_user_info table")
-		log.Print(err)
-		return err
+		createStatement.Keyword("CREATEUSER")
 	}
-
-	log.Printf("usesysid for user is %s", usesysid)
-
-	d.SetId(usesysid)
-
-	readErr := readRedshiftUser(d, tx)
-
-	if readErr != nil {
-		tx.Rollback()
-		return readErr
+	if v, ok := d.GetOk("session_timeout"); ok {
+		createStatement.Keyword("SESSION TIMEOUT", strconv.Itoa(v.(int)))
 	}
 
-	tx.Commit()
-	return nil
+	return createStatement.String(), nil
 }
 
-func resourceRedshiftUserRead(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftUserRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
 
-	tx, txErr := redshiftClient.Begin()
-
-	if txErr != nil {
-		panic(txErr)
-	}
-
-	err := readRedshiftUser(d, tx)
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutRead, userObject(d))
+	defer cancel()
 
+	exists, err := resourceRedshiftUserExists(ctx, d, redshiftClient)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return diagnostics(err)
+	}
+	if !exists {
+		log.Printf("[WARN] %s no longer exists, removing it from the state", userObject(d))
+		d.SetId("")
+		return nil
 	}
 
-	tx.Commit()
-	return nil
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, userObject(d), func(tx *sql.Tx) error {
+		if err := readRedshiftUser(ctx, d, tx); err != nil {
+			return err
+		}
+		if meta.(*Client).detectsPasswordDrift(ctx, redshiftClient) {
+			return readPasswordDrift(ctx, d, tx)
+		}
+		return nil
+	}))
 }
 
-func readRedshiftUser(d *schema.ResourceData, tx *sql.Tx) error {
+func readRedshiftUser(ctx context.Context, d *schema.ResourceData, tx *sql.Tx) error {
 
 	var (
-		usename      string
-		usecreatedb  bool
-		usesuper     bool
-		valuntil     sql.NullString
-		useconnlimit sql.NullString
+		usename          string
+		usecreatedb      bool
+		usesuper         bool
+		valuntil         sql.NullString
+		useconnlimit     sql.NullString
+		useconfig        pq.StringArray
+		passwordDisabled bool
+		syslogaccess     sql.NullString
+		sessiontimeout   sql.NullInt64
+		externalUserID   sql.NullString
 	)
 
-	var readUserQuery = "select usename, usecreatedb, usesuper, valuntil, useconnlimit " +
-		"from pg_user_info where usesysid = $1"
+	// A disabled password is stored as null. svl_user_info has the attributes pg_user_info lacks.
+	var readUserQuery = "select u.usename, u.usecreatedb, u.usesuper, u.valuntil, u.useconnlimit, u.useconfig, u.passwd is null, " +
+		"s.syslogaccess, s.sessiontimeout, s.external_user_id " +
+		"from pg_user_info u left join svl_user_info s on s.usesysid = u.usesysid where u.usesysid = $1"
 
-	log.Print("Reading redshift user with query: " + readUserQuery)
-
-	err := tx.QueryRow(readUserQuery, d.Id()).Scan(&usename, &usecreatedb, &usesuper, &valuntil, &useconnlimit)
+	err := tx.QueryRowContext(ctx, readUserQuery, d.Id()).Scan(&usename, &usecreatedb, &usesuper, &valuntil, &useconnlimit, &useconfig,
+		&passwordDisabled, &syslogaccess, &sessiontimeout, &externalUserID)
 
 	if err != nil {
-		log.Print("Reading user does not exist")
-		log.Print(err)
-		return err
+		return wrapError("read", userObject(d), err)
 	}
 
 	log.Print("Succesfully read redshift user")
@@ -247,128 +383,352 @@ func readRedshiftUser(d *schema.ResourceData, tx *sql.Tx) error {
 		d.Set("connection_limit", nil)
 	}
 
+	d.Set("session_parameters", readSessionParameters(d.Get("session_parameters").(map[string]interface{}), useconfig))
+	d.Set("password_disabled", passwordDisabled)
+
+	if syslogaccess.Valid {
+		d.Set("syslog_access", strings.ToUpper(syslogaccess.String))
+	}
+	d.Set("session_timeout", int(sessiontimeout.Int64))
+	d.Set("external_id", externalUserID.String)
+
 	return nil
 }
 
-func resourceRedshiftUserUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceRedshiftUserUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
 
 	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+		return diagnostics(dbErr)
 	}
-	tx, txErr := redshiftClient.Begin()
 
-	if txErr != nil {
-		panic(txErr)
-	}
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutUpdate, userObject(d))
+	defer cancel()
 
-	if d.HasChange("username") {
+	return diagnostics(meta.(*Client).withTransaction(ctx, redshiftClient, userObject(d), func(tx *sql.Tx) error {
 
-		oldUsername, newUsername := d.GetChange("username")
-		alterUserQuery := "alter user " + oldUsername.(string) + " rename to " + newUsername.(string)
+		renamed := d.HasChange("username")
+		if renamed {
 
-		if _, err := tx.Exec(alterUserQuery); err != nil {
-			return err
+			oldUsername, newUsername := d.GetChange("username")
+			alterUserQuery := sqlbuilder.New("ALTER USER").Ident(oldUsername.(string)).Keyword("RENAME TO").Ident(newUsername.(string)).String()
+
+			if _, err := tx.ExecContext(ctx, alterUserQuery); err != nil {
+				return wrapError("rename", "user "+oldUsername.(string), err)
+			}
+		}
+
+		rotationDays := d.Get("rotation_days").(int)
+		lastRotatedAt, _ := d.GetChange("last_rotated_at")
+
+		regenerate := d.Get("generate_password").(bool) &&
+			(d.HasChange("generate_password") || d.HasChange("pgp_key") || d.HasChange("password_length") ||
+				d.HasChange("keepers") || rotationDue(lastRotatedAt.(string), rotationDays, time.Now()))
+
+		// A new rotation period moves the expiry of the current password
+		rescheduled := d.Get("generate_password").(bool) && rotationDays > 0 && d.HasChange("rotation_days") && !regenerate && !renamed
+		if rescheduled {
+			// rotationDue has already rotated passwords whose last_rotated_at can not be read
+			rotatedAt, _ := time.Parse(time.RFC3339, lastRotatedAt.(string))
+			d.Set("valid_until", rotationExpiry(rotatedAt, rotationDays))
 		}
 
 		//If name changes we also need to reset the password
-		if err := resetPassword(tx, d, newUsername.(string)); err != nil {
-			return err
+		if renamed || regenerate || rescheduled || d.HasChange("password") || d.HasChange("password_disabled") || d.HasChange("valid_until") {
+
+			password := d.Get("password").(string)
+			if d.Get("generate_password").(bool) {
+				// The generated password is not known, so a new one is needed when it has to be set again
+				password = ""
+				if renamed || regenerate {
+					var err error
+					if password, err = generateUserPassword(d); err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := resetPassword(ctx, tx, d, d.Get("username").(string), password); err != nil {
+				return err
+			}
 		}
-	} else if d.HasChange("password") || d.HasChange("password_disabled") || d.HasChange("valid_until") {
-		if err := resetPassword(tx, d, d.Get("username").(string)); err != nil {
-			return err
+
+		if !d.Get("generate_password").(bool) {
+			d.Set("encrypted_password", "")
+			d.Set("key_fingerprint", "")
+			d.Set("generated_password", "")
+			d.Set("last_rotated_at", "")
 		}
-	}
 
-	if d.HasChange("createdb") {
+		alterUser := func() *sqlbuilder.Builder {
+			return sqlbuilder.New("ALTER USER").Ident(d.Get("username").(string))
+		}
 
-		if v, ok := d.GetOk("createdb"); ok && v.(bool) {
-			if _, err := tx.Exec("alter user " + d.Get("username").(string) + " createdb"); err != nil {
-				return err
+		if d.HasChange("createdb") {
+
+			if v, ok := d.GetOk("createdb"); ok && v.(bool) {
+				if _, err := tx.ExecContext(ctx, alterUser().Keyword("CREATEDB").String()); err != nil {
+					return wrapError("alter createdb of", userObject(d), err)
+				}
+			} else {
+				if _, err := tx.ExecContext(ctx, alterUser().Keyword("NOCREATEDB").String()); err != nil {
+					return wrapError("alter createdb of", userObject(d), err)
+				}
 			}
-		} else {
-			if _, err := tx.Exec("alter user " + d.Get("username").(string) + " nocreatedb"); err != nil {
-				return err
+		}
+		//TODO What if value is removed?
+		if d.HasChange("connection_limit") {
+			if _, err := tx.ExecContext(ctx, alterUser().Keyword("CONNECTION LIMIT", d.Get("connection_limit").(string)).String()); err != nil {
+				return wrapError("alter connection limit of", userObject(d), err)
 			}
 		}
-	}
-	//TODO What if value is removed?
-	if d.HasChange("connection_limit") {
-		if _, err := tx.Exec("alter user " + d.Get("username").(string) + " CONNECTION LIMIT " + d.Get("connection_limit").(string)); err != nil {
-			return err
+		if d.HasChange("syslog_access") {
+			if _, err := tx.ExecContext(ctx, alterUser().Keyword("SYSLOG ACCESS", d.Get("syslog_access").(string)).String()); err != nil {
+				return wrapError("alter syslog access of", userObject(d), err)
+			}
 		}
-	}
-	if d.HasChange("syslog_access") {
-		if _, err := tx.Exec("alter user " + d.Get("username").(string) + " SYSLOG ACCESS " + d.Get("syslog_access").(string)); err != nil {
-			return err
+		if d.HasChange("superuser") {
+			if v, ok := d.GetOk("superuser"); ok && v.(bool) {
+				if _, err := tx.ExecContext(ctx, alterUser().Keyword("CREATEUSER").String()); err != nil {
+					return wrapError("alter superuser of", userObject(d), err)
+				}
+			} else {
+				if _, err := tx.ExecContext(ctx, alterUser().Keyword("NOCREATEUSER").String()); err != nil {
+					return wrapError("alter superuser of", userObject(d), err)
+				}
+			}
 		}
-	}
-	if d.HasChange("superuser") {
-		if v, ok := d.GetOk("superuser"); ok && v.(bool) {
-			if _, err := tx.Exec("alter user " + d.Get("username").(string) + " CREATEUSER "); err != nil {
-				return err
+		if d.HasChange("session_timeout") {
+			statement := alterUser().Keyword("RESET SESSION TIMEOUT").String()
+			if v := d.Get("session_timeout").(int); v > 0 {
+				statement = alterUser().Keyword("SESSION TIMEOUT", strconv.Itoa(v)).String()
 			}
-		} else {
-			if _, err := tx.Exec("alter user " + d.Get("username").(string) + " NOCREATEUSER"); err != nil {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return wrapError("alter session timeout of", userObject(d), err)
+			}
+		}
+		if d.HasChange("session_parameters") {
+			if err := updateSessionParameters(ctx, d, tx); err != nil {
 				return err
 			}
 		}
-	}
 
-	err := readRedshiftUser(d, tx)
+		return readRedshiftUser(ctx, d, tx)
+	}))
+}
+
+func resetPassword(ctx context.Context, tx *sql.Tx, d *schema.ResourceData, username string, password string) error {
 
+	statement, err := resetPasswordStatement(d, username, password)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return wrapError("reset password of", "user "+username, err)
+	}
+	if statement == "" {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		return wrapError("reset password of", "user "+username, err)
 	}
-
-	tx.Commit()
 	return nil
 }
 
-func resetPassword(tx *sql.Tx, d *schema.ResourceData, username string) error {
+// resetPasswordStatement returns the ALTER USER statement setting password and valid_until.
+// An empty password only sets valid_until, and nothing is returned when that is not set either.
+func resetPasswordStatement(d *schema.ResourceData, username string, password string) (string, error) {
 
 	if v, ok := d.GetOk("password_disabled"); ok && v.(bool) {
+		return sqlbuilder.New("ALTER USER").Ident(username).Keyword("PASSWORD DISABLE").String(), nil
+	}
 
-		var disablePasswordQuery = "alter user " + username + " password disable"
+	resetPasswordQuery := sqlbuilder.New("ALTER USER").Ident(username)
+	if password != "" {
+		hash, err := hashPassword(d.Get("password_hash_method").(string), password, username)
+		if err != nil {
+			return "", err
+		}
+		resetPasswordQuery.Keyword("PASSWORD").Literal(hash)
+	} else if _, ok := d.GetOk("valid_until"); !ok {
+		return "", nil
+	}
 
-		if _, err := tx.Exec(disablePasswordQuery); err != nil {
-			return err
+	if v, ok := d.GetOk("valid_until"); ok {
+		resetPasswordQuery.Keyword("VALID UNTIL").Literal(v.(string))
+	}
+	return resetPasswordQuery.String(), nil
+}
+
+// generateUserPassword returns a new password for the user and stores it encrypted with pgp_key,
+// or in generated_password when store_plaintext_password is set. valid_until is moved to the next
+// rotation.
+func generateUserPassword(d *schema.ResourceData) (string, error) {
+
+	password, err := generatePassword(d.Get("password_length").(int))
+	if err != nil {
+		return "", err
+	}
+
+	if pgpKey := d.Get("pgp_key").(string); pgpKey != "" {
+		fingerprint, encrypted, err := encryptPassword(pgpKey, password)
+		if err != nil {
+			return "", wrapError("encrypt generated password of", userObject(d), err)
+		}
+		d.Set("encrypted_password", encrypted)
+		d.Set("key_fingerprint", fingerprint)
+		d.Set("generated_password", "")
+	} else if d.Get("store_plaintext_password").(bool) {
+		d.Set("encrypted_password", "")
+		d.Set("key_fingerprint", "")
+		d.Set("generated_password", password)
+	} else {
+		return "", newAttributeError("generate password of", userObject(d), "pgp_key", "pgp_key has to be set, or store_plaintext_password to keep the password in plain text")
+	}
+
+	rotatedAt := time.Now().UTC()
+	d.Set("last_rotated_at", rotatedAt.Format(time.RFC3339))
+	if rotationDays := d.Get("rotation_days").(int); rotationDays > 0 {
+		d.Set("valid_until", rotationExpiry(rotatedAt, rotationDays))
+	}
+	return password, nil
+}
+
+func resourceRedshiftUserCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+
+	rotationDays := d.Get("rotation_days").(int)
+
+	if !d.Get("generate_password").(bool) {
+		if rotationDays > 0 || len(d.Get("keepers").(map[string]interface{})) > 0 {
+			return fmt.Errorf("rotation_days and keepers need generate_password to be set for %s", d.Get("username"))
 		}
 		return nil
+	}
 
-	} else {
-		var resetPasswordQuery = "alter user " + username + " password '" + d.Get("password").(string) + "' "
-		if v, ok := d.GetOk("valid_until"); ok {
-			resetPasswordQuery += " VALID UNTIL '" + v.(string) + "'"
+	if d.Get("password_disabled").(bool) {
+		return fmt.Errorf("generate_password and password_disabled cannot both be set for %s", d.Get("username"))
+	}
 
+	// The generated password is only kept in plain text when asked to
+	if d.Get("pgp_key").(string) == "" && !d.Get("store_plaintext_password").(bool) {
+		return fmt.Errorf("pgp_key has to be set to generate a password for %s, or store_plaintext_password to keep it in plain text in generated_password", d.Get("username"))
+	}
+
+	// A new password is generated when any of these change or the current one is due for
+	// rotation, see resourceRedshiftUserUpdate
+	lastRotatedAt, _ := d.GetChange("last_rotated_at")
+	regenerate := d.HasChange("generate_password") || d.HasChange("pgp_key") || d.HasChange("password_length") ||
+		d.HasChange("username") || d.HasChange("keepers") ||
+		(d.Id() != "" && rotationDue(lastRotatedAt.(string), rotationDays, time.Now()))
+
+	if regenerate {
+		for _, attribute := range []string{"encrypted_password", "key_fingerprint", "generated_password", "last_rotated_at"} {
+			if err := d.SetNewComputed(attribute); err != nil {
+				return err
+			}
 		}
-		if _, err := tx.Exec(resetPasswordQuery); err != nil {
+	}
+	if rotationDays > 0 && (regenerate || d.HasChange("rotation_days")) {
+		return d.SetNewComputed("valid_until")
+	}
+	return nil
+}
+
+func resourceRedshiftUserDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+
+	redshiftClient, dbErr := meta.(*Client).getResourceConnection(d, "database")
+
+	if dbErr != nil {
+		return diagnostics(dbErr)
+	}
+
+	ctx, cancel := meta.(*Client).operationContext(ctx, d, schema.TimeoutDelete, userObject(d))
+	defer cancel()
+	client := meta.(*Client)
+
+	return diagnostics(client.withTransaction(ctx, redshiftClient, userObject(d), func(tx *sql.Tx) error {
+		newOwner, err := reassignOwnedTo(ctx, tx, d)
+		if err != nil {
 			return err
 		}
-		return nil
+		return dropUser(ctx, d, client, tx, client.resourceDatabase(d, "database"), newOwner)
+	}))
+}
+
+// reassignOwnedTo returns the user the objects of the deleted user are given to: reassign_owned_to,
+// a user id or name, or the session user of q. It is empty when fail_on_owned_objects is set.
+func reassignOwnedTo(ctx context.Context, q Queryer, d *schema.ResourceData) (string, error) {
+
+	if d.Get("fail_on_owned_objects").(bool) {
+		return "", nil
 	}
+
+	newOwner := d.Get("reassign_owned_to").(string)
+	if newOwner == "" {
+		// The session user is not always the provider user, temporary credentials log in as the
+		// IAM identity or with an IAM: prefix
+		if err := q.QueryRowContext(ctx, "SELECT current_user").Scan(&newOwner); err != nil {
+			return "", wrapError("find the user to reassign the objects of", userObject(d), err)
+		}
+		return newOwner, nil
+	}
+	if usesysid, err := strconv.Atoi(newOwner); err == nil {
+		if newOwner, err = GetUsernameForUsesysid(ctx, q, usesysid); err != nil {
+			return "", err
+		}
+	}
+	if newOwner == d.Get("username").(string) {
+		return "", newAttributeError("drop", userObject(d), "reassign_owned_to", "reassign_owned_to cannot be the user itself")
+	}
+	return newOwner, nil
 }
 
-func resourceRedshiftUserDelete(d *schema.ResourceData, meta interface{}) error {
+// dropUser gives the objects owned by the user to newOwner, revokes its privileges in every database
+// and drops it. tx is a transaction in database. When newOwner is empty the user is only dropped if
+// it owns nothing.
+func dropUser(ctx context.Context, d *schema.ResourceData, client *Client, tx *sql.Tx, database string, newOwner string) error {
 
-	redshiftClient, dbErr := meta.(*Client).getConnection(d.Get("database").(string))
+	reassignStatements, err := ownedObjectStatements(ctx, d, tx)
+	if err != nil {
+		return err
+	}
 
-	if dbErr != nil {
-		log.Print(dbErr)
-		return dbErr
+	if newOwner == "" && len(reassignStatements) > 0 {
+		objects := make([]string, len(reassignStatements))
+		for i, statement := range reassignStatements {
+			objects[i] = ownedObject(statement)
+		}
+		return newError("drop", userObject(d), "it owns "+strings.Join(objects, ", "))
 	}
-	redshiftClientConfig := meta.(*Client).config
 
-	tx, txErr := redshiftClient.Begin()
+	for _, statement := range reassignStatements {
+		_, err := tx.ExecContext(ctx, statement+sqlbuilder.QuoteIdentifier(newOwner))
 
-	if txErr != nil {
-		return fmt.Errorf("Could not begin redshift transaction: %s", txErr)
+		if err != nil {
+			return wrapError("reassign objects owned by", userObject(d), err)
+		}
 	}
 
+	//We need to drop all privileges and default privileges
+	revoked, err := client.revokeAllPrivileges(ctx, tx, database, grantee{name: d.Get("username").(string)})
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Revoked %d privileges of %s before dropping it", len(revoked), userObject(d))
+
+	_, dropUserErr := tx.ExecContext(ctx, sqlbuilder.New("DROP USER").Ident(d.Get("username").(string)).String())
+
+	return wrapError("drop", userObject(d), dropUserErr)
+}
+
+// ownedObject returns the object a statement of ownedObjectStatements changes the owner of, such
+// as: table public.orders
+func ownedObject(statement string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(statement, "alter "), " owner to ")
+}
+
+// ownedObjectStatements returns an ALTER ... OWNER TO statement, without the new owner, for every
+// object the user owns
+func ownedObjectStatements(ctx context.Context, d *schema.ResourceData, tx *sql.Tx) ([]string, error) {
+
 	// https://docs.aws.amazon.com/redshift/latest/dg/r_DROP_USER.html
 	// If a user owns an object, first drop the object or change its ownership to another user before dropping
 	// the original user. If the user has privileges for an object, first revoke the privileges before dropping
@@ -418,13 +778,10 @@ func resourceRedshiftUserDelete(d *schema.ResourceData, meta interface{}) error
 		OWNER("userid", "ddl")
 		WHERE owner.userid = $1;`
 
-	rows, reassignOwnerStatementErr := tx.Query(reassignOwnerGenerator, d.Id())
-
-	defer rows.Close()
+	rows, reassignOwnerStatementErr := tx.QueryContext(ctx, reassignOwnerGenerator, d.Id())
 
 	if reassignOwnerStatementErr != nil {
-		tx.Rollback()
-		return reassignOwnerStatementErr
+		return nil, wrapError("find objects owned by", userObject(d), reassignOwnerStatementErr)
 	}
 
 	var reassignStatements []string
@@ -435,65 +792,25 @@ func resourceRedshiftUserDelete(d *schema.ResourceData, meta interface{}) error
 
 		err := rows.Scan(&reassignStatement)
 		if err != nil {
-			//Im not sure how this can happen
-			tx.Rollback()
-			return err
+			rows.Close()
+			return nil, wrapError("find objects owned by", userObject(d), err)
 		}
 		reassignStatements = append(reassignStatements, reassignStatement)
 	}
+	rows.Close()
 
-	for _, statement := range reassignStatements {
-		_, err := tx.Exec(statement + redshiftClientConfig.user)
-
-		if err != nil {
-			//Im not sure how this can happen
-			tx.Rollback()
-			return err
-		}
-	}
-
-	//We need to drop all privileges and default privileges
-	rows, schemasError := redshiftClient.Query("select nspname from pg_namespace")
-	defer rows.Close()
-	if schemasError != nil {
-		return schemasError
-	}
-
-	for rows.Next() {
-		var schemaName string
-		err := rows.Scan(&schemaName)
-		if err != nil {
-			//Im not sure how this can happen
-			return err
-		}
-		redshiftClient.Exec("REVOKE ALL ON ALL TABLES IN SCHEMA " + schemaName + " FROM " + d.Get("username").(string))
-		redshiftClient.Exec("ALTER DEFAULT PRIVILEGES IN SCHEMA " + schemaName + " REVOKE ALL ON TABLES FROM " + d.Get("username").(string) + " CASCADE")
-	}
-
-	_, dropUserErr := tx.Exec("DROP USER " + d.Get("username").(string))
-
-	if dropUserErr != nil {
-		tx.Rollback()
-		return dropUserErr
-	}
-
-	tx.Commit()
-	return nil
-}
-
-func resourceRedshiftUserImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
-	if err := resourceRedshiftUserRead(d, meta); err != nil {
-		return nil, err
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("find objects owned by", userObject(d), err)
 	}
-	return []*schema.ResourceData{d}, nil
+	return reassignStatements, nil
 }
 
 type Queryer interface {
-	Query(query string, args ...interface{}) (*sql.Rows, error)
-	QueryRow(query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-func GetUsersnamesForUsesysid(q Queryer, usersIdsInterface []interface{}) []string {
+func GetUsersnamesForUsesysid(ctx context.Context, q Queryer, usersIdsInterface []interface{}) ([]string, error) {
 
 	var usersIds = make([]int, 0)
 
@@ -506,21 +823,17 @@ func GetUsersnamesForUsesysid(q Queryer, usersIdsInterface []interface{}) []stri
 	//I couldnt figure out how to pass a slice to go sql
 	var selectUserQuery = fmt.Sprintf("select usename from pg_user_info where usesysid in (%s)", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(usersIds)), ","), "[]"))
 
-	log.Print("Select user query: " + selectUserQuery)
-
-	rows, err := q.Query(selectUserQuery)
+	rows, err := q.QueryContext(ctx, selectUserQuery)
 
 	if err != nil {
-		// handle this error better than this
-		panic(err)
+		return nil, wrapError("look up users", fmt.Sprint(usersIds), err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var username string
 		err = rows.Scan(&username)
 		if err != nil {
-			// handle this error
-			panic(err)
+			return nil, wrapError("look up users", fmt.Sprint(usersIds), err)
 		}
 
 		usernames = append(usernames, username)
@@ -528,8 +841,21 @@ func GetUsersnamesForUsesysid(q Queryer, usersIdsInterface []interface{}) []stri
 	// get any error encountered during iteration
 	err = rows.Err()
 	if err != nil {
-		panic(err)
+		return nil, wrapError("look up users", fmt.Sprint(usersIds), err)
+	}
+
+	if len(usernames) != len(usersIds) {
+		return nil, newError("look up users", fmt.Sprint(usersIds), fmt.Sprintf("only %d of %d users exist", len(usernames), len(usersIds)))
 	}
 
-	return usernames
+	return usernames, nil
+}
+
+// GetUsernameForUsesysid returns the name of a single user
+func GetUsernameForUsesysid(ctx context.Context, q Queryer, usesysid int) (string, error) {
+	usernames, err := GetUsersnamesForUsesysid(ctx, q, []interface{}{usesysid})
+	if err != nil {
+		return "", err
+	}
+	return usernames[0], nil
 }
@@ -0,0 +1,221 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestCreateUserStatement(t *testing.T) {
+	cases := []struct {
+		raw      map[string]interface{}
+		expected string
+	}{
+		{
+			map[string]interface{}{"username": "alice", "password": "Testpass123"},
+			`CREATE USER "alice" WITH PASSWORD 'Testpass123' CONNECTION LIMIT UNLIMITED SYSLOG ACCESS RESTRICTED`,
+		},
+		{
+			map[string]interface{}{
+				"username":         "IAM:Alice-Smith",
+				"password":         `it's\secret`,
+				"valid_until":      "2030-01-01",
+				"createdb":         true,
+				"connection_limit": "4",
+				"syslog_access":    "UNRESTRICTED",
+				"superuser":        true,
+				"session_timeout":  3600,
+			},
+			`CREATE USER "IAM:Alice-Smith" WITH PASSWORD 'it''s\\secret' VALID UNTIL '2030-01-01' CREATEDB CONNECTION LIMIT 4 SYSLOG ACCESS UNRESTRICTED CREATEUSER SESSION TIMEOUT 3600`,
+		},
+		{
+			map[string]interface{}{"username": `bad"name`, "password_disabled": true},
+			`CREATE USER "bad""name" WITH PASSWORD DISABLE CONNECTION LIMIT UNLIMITED SYSLOG ACCESS RESTRICTED`,
+		},
+	}
+
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, redshiftUser().Schema, c.raw)
+		actual, err := createUserStatement(d, d.Get("password").(string))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if actual != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, actual)
+		}
+	}
+
+	d := schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{"username": "alice"})
+	if _, err := createUserStatement(d, d.Get("password").(string)); err == nil {
+		t.Fatal("expected an error when neither password nor password_disabled is set")
+	}
+}
+
+func TestResetPasswordStatement(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{
+		"username":    "alice",
+		"password":    "x'; DROP USER bob; --",
+		"valid_until": "2030-01-01",
+	})
+	expected := `ALTER USER "Alice" PASSWORD 'x''; DROP USER bob; --' VALID UNTIL '2030-01-01'`
+	if actual, err := resetPasswordStatement(d, "Alice", d.Get("password").(string)); err != nil || actual != expected {
+		t.Errorf("expected %s, got %s: %v", expected, actual, err)
+	}
+
+	d = schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{"username": "alice", "password_disabled": true})
+	expected = `ALTER USER "alice" PASSWORD DISABLE`
+	if actual, err := resetPasswordStatement(d, "alice", d.Get("password").(string)); err != nil || actual != expected {
+		t.Errorf("expected %s, got %s: %v", expected, actual, err)
+	}
+
+	// The md5 hash is of the new name when a user is renamed
+	d = schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{
+		"username":             "testusernew",
+		"password":             "Testpass123",
+		"password_hash_method": "md5",
+	})
+	expected = `ALTER USER "testusernew" PASSWORD '` + testusernewHash + `'`
+	if actual, err := resetPasswordStatement(d, "testusernew", d.Get("password").(string)); err != nil || actual != expected {
+		t.Errorf("expected %s, got %s: %v", expected, actual, err)
+	}
+}
+
+func TestValidateConnectionLimit(t *testing.T) {
+	for _, valid := range []string{"UNLIMITED", "0", "500"} {
+		if _, errs := validateConnectionLimit(valid, "connection_limit"); len(errs) > 0 {
+			t.Errorf("expected %q to be valid: %v", valid, errs)
+		}
+	}
+	for _, invalid := range []string{"-1", "unlimited", "4; DROP USER x"} {
+		if _, errs := validateConnectionLimit(invalid, "connection_limit"); len(errs) == 0 {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+// cannedUser answers the query of readRedshiftUser
+func cannedUser(username string, useconfig interface{}, passwordDisabled bool, syslogAccess string, sessionTimeout int64, externalID string) cannedResult {
+	return cannedResult{
+		match: "svl_user_info",
+		columns: []string{"usename", "usecreatedb", "usesuper", "valuntil", "useconnlimit", "useconfig",
+			"?column?", "syslogaccess", "sessiontimeout", "external_user_id"},
+		rows: [][]driver.Value{{username, false, false, nil, "UNLIMITED", useconfig, passwordDisabled, syslogAccess, sessionTimeout, externalID}},
+	}
+}
+
+func TestReadRedshiftUserAttributes(t *testing.T) {
+	client := cannedClient(cannedUser("service", nil, true, "unrestricted", 3600, "a1b2c3"))
+	defer client.Close()
+
+	d := schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{
+		"username": "service",
+		"password": "Testpass123",
+	})
+	d.SetId("100")
+
+	err := client.withTransaction(context.Background(), client.connections["dev"], userObject(d), func(tx *sql.Tx) error {
+		return readRedshiftUser(context.Background(), d, tx)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"password_disabled": true,
+		"syslog_access":     "UNRESTRICTED",
+		"session_timeout":   3600,
+		"external_id":       "a1b2c3",
+	}
+	for attribute, value := range expected {
+		if actual := d.Get(attribute); actual != value {
+			t.Errorf("expected %s to be %v, got %v", attribute, value, actual)
+		}
+	}
+}
+
+func TestValidateSessionTimeout(t *testing.T) {
+	for _, valid := range []int{0, 60, 1728000} {
+		if _, errors := validateSessionTimeout(valid, "session_timeout"); len(errors) != 0 {
+			t.Errorf("expected %d to be valid, got %v", valid, errors)
+		}
+	}
+	for _, invalid := range []int{-1, 59, 1728001} {
+		if _, errors := validateSessionTimeout(invalid, "session_timeout"); len(errors) == 0 {
+			t.Errorf("expected %d to be rejected", invalid)
+		}
+	}
+}
+
+func TestDropUserOwnedObjects(t *testing.T) {
+	owned := cannedResult{
+		match:   `OWNER("userid", "ddl")`,
+		columns: []string{"ddl"},
+		rows:    [][]driver.Value{{"alter schema etl owner to "}, {"alter table etl.orders owner to "}},
+	}
+	owners := cannedResult{match: "usesysid in (200)", columns: []string{"usename"}, rows: [][]driver.Value{{"etl_owner"}}}
+	sessionUser := cannedResult{match: "current_user", columns: []string{"current_user"}, rows: [][]driver.Value{{"IAM:admin"}}}
+
+	drop := func(raw map[string]interface{}) ([]string, error) {
+		client, connector := cannedClientConnector(owned, owners, sessionUser)
+		defer client.Close()
+
+		d := schema.TestResourceDataRaw(t, redshiftUser().Schema, raw)
+		d.SetId("100")
+
+		db := client.connections["dev"]
+		err := client.withTransaction(context.Background(), db, userObject(d), func(tx *sql.Tx) error {
+			newOwner, err := reassignOwnedTo(context.Background(), tx, d)
+			if err != nil {
+				return err
+			}
+			return dropUser(context.Background(), d, client, tx, "dev", newOwner)
+		})
+
+		var statements []string
+		for _, statement := range connector.executed {
+			if statement != "BEGIN" && statement != "COMMIT" && statement != "ROLLBACK" {
+				statements = append(statements, statement)
+			}
+		}
+		return statements, err
+	}
+
+	cases := []struct {
+		raw      map[string]interface{}
+		newOwner string
+	}{
+		{map[string]interface{}{"username": "etl"}, `"IAM:admin"`},
+		{map[string]interface{}{"username": "etl", "reassign_owned_to": "etl_owner"}, `"etl_owner"`},
+		{map[string]interface{}{"username": "etl", "reassign_owned_to": "200"}, `"etl_owner"`},
+	}
+	for _, c := range cases {
+		statements, err := drop(c.raw)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		expected := []string{
+			"alter schema etl owner to " + c.newOwner,
+			"alter table etl.orders owner to " + c.newOwner,
+			`DROP USER "etl"`,
+		}
+		if strings.Join(statements, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("expected %q, got %q", expected, statements)
+		}
+	}
+
+	statements, err := drop(map[string]interface{}{"username": "etl", "fail_on_owned_objects": true})
+	if err == nil || !strings.Contains(err.Error(), "it owns schema etl, table etl.orders") {
+		t.Errorf("expected the owned objects to be listed, got %v", err)
+	}
+	if len(statements) != 0 {
+		t.Errorf("expected nothing to be changed, got %q", statements)
+	}
+
+	if _, err := drop(map[string]interface{}{"username": "etl", "reassign_owned_to": "etl"}); err == nil {
+		t.Error("expected an error when objects are reassigned to the dropped user")
+	}
+}
@@ -0,0 +1,137 @@
+package redshift
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	retryMinBackoff = 500 * time.Millisecond
+	retryMaxBackoff = 10 * time.Second
+)
+
+// SQLSTATE codes that mean the statement did not take effect and can safely be run again
+var retryableErrorCodes = map[pq.ErrorCode]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"53300": true, // too_many_connections
+	"57P03": true, // cannot_connect_now
+	"08000": true, // connection_exception
+	"08001": true, // sqlclient_unable_to_establish_sqlconnection
+	"08003": true, // connection_does_not_exist
+	"08004": true, // sqlserver_rejected_establishment_of_sqlconnection
+	"08006": true, // connection_failure
+}
+
+// Redshift reports its own concurrency errors, eg "1023 Serializable isolation violation", as internal
+// errors, so they are recognised by message.
+// https://docs.aws.amazon.com/redshift/latest/dg/c_serial_isolation.html
+var retryableErrorMessages = []string{
+	"serializable isolation violation",
+	"concurrent transaction",
+}
+
+// errCommitUnknown is a COMMIT the connection failed during. It may have reached the cluster, so
+// running the transaction again could apply it twice.
+var errCommitUnknown = errors.New("the connection failed during COMMIT, the transaction may have been committed")
+
+// isRetryableError reports whether err is a transient failure, such as Redshift rejecting
+// concurrent DDL, after which the whole transaction can be retried
+func isRetryableError(err error) bool {
+
+	if errors.Is(err, errCommitUnknown) {
+		return false
+	}
+
+	if e, ok := err.(*Error); ok {
+		err = e.Err
+	}
+
+	if err == driver.ErrBadConn {
+		return true
+	}
+
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+
+	if retryableErrorCodes[pqErr.Code] || strings.HasPrefix(strings.TrimSpace(pqErr.Message), "1023") {
+		return true
+	}
+
+	message := strings.ToLower(pqErr.Message + " " + pqErr.Detail)
+	for _, retryable := range retryableErrorMessages {
+		if strings.Contains(message, retryable) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isConnectionError reports whether err is the connection to the cluster failing
+func isConnectionError(err error) bool {
+
+	if err == driver.ErrBadConn {
+		return true
+	}
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code.Class() == "08"
+}
+
+// retryBackoff returns how long to wait before the given retry, growing exponentially with full jitter
+// so resources that conflicted with each other do not retry in lockstep
+func retryBackoff(retry int) time.Duration {
+	backoff := retryMaxBackoff
+	if retry < 10 {
+		backoff = retryMinBackoff << uint(retry)
+		if backoff > retryMaxBackoff {
+			backoff = retryMaxBackoff
+		}
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+// withRetry runs f, running it again up to maxRetries times while it fails with a retryable error
+// and ctx is not done
+func (c *Client) withRetry(ctx context.Context, object string, f func() error) error {
+
+	var err error
+
+	for retry := 0; ; retry++ {
+		err = f()
+		if err == nil || !isRetryableError(err) || retry >= c.config.maxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := retryBackoff(retry)
+		log.Printf("Retrying %s in %s after transient error (%d/%d): %s", object, backoff, retry+1, c.config.maxRetries, err)
+		if sleepErr := c.sleep(ctx, backoff); sleepErr != nil {
+			log.Printf("[WARN] Stopped retrying %s: %s", object, sleepErr)
+			return err
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with the error of ctx when it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
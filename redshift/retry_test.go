@@ -0,0 +1,194 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{&pq.Error{Code: "40001", Message: "could not serialize access"}, true},
+		{&pq.Error{Code: "XX000", Message: "1023", Detail: "Serializable isolation violation on table - 100, transactions forming the cycle are: 1, 2"}, true},
+		{&pq.Error{Code: "XX000", Message: "tuple concurrently updated: concurrent transaction"}, true},
+		{&pq.Error{Code: "53300", Message: "too many connections"}, true},
+		{wrapError("grant", "group analysts", &pq.Error{Code: "40P01", Message: "deadlock detected"}), true},
+		{driver.ErrBadConn, true},
+		{&pq.Error{Code: "42501", Message: "permission denied"}, false},
+		{&pq.Error{Code: "42601", Message: "syntax error"}, false},
+		{newError("grant", "group analysts", "Must have at least 1 privilege"), false},
+		{errors.New("something else"), false},
+	}
+
+	for _, c := range cases {
+		if actual := isRetryableError(c.err); actual != c.retryable {
+			t.Errorf("isRetryableError(%s): expected %t", c.err, c.retryable)
+		}
+	}
+}
+
+func TestRetryBackoffIsBounded(t *testing.T) {
+	for retry := 0; retry < 100; retry++ {
+		if backoff := retryBackoff(retry); backoff <= 0 || backoff > retryMaxBackoff {
+			t.Fatalf("backoff %s for retry %d is out of bounds", backoff, retry)
+		}
+	}
+}
+
+func TestWithTransactionRetriesTransientErrors(t *testing.T) {
+	serializationErr := &pq.Error{Code: "40001", Message: "could not serialize access"}
+
+	cases := []struct {
+		failures   int
+		maxRetries int
+		attempts   int
+		succeeds   bool
+	}{
+		{failures: 0, maxRetries: 3, attempts: 1, succeeds: true},
+		{failures: 2, maxRetries: 3, attempts: 3, succeeds: true},
+		{failures: 5, maxRetries: 3, attempts: 4, succeeds: false},
+	}
+
+	for _, c := range cases {
+		client := (&Config{maxRetries: c.maxRetries}).Client()
+		var slept []time.Duration
+		client.sleep = func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		}
+
+		db := sql.OpenDB(&fakeConnector{})
+
+		attempts := 0
+		err := client.withTransaction(context.Background(), db, "group analysts", func(tx *sql.Tx) error {
+			attempts++
+			if attempts <= c.failures {
+				return wrapError("grant", "group analysts", serializationErr)
+			}
+			return nil
+		})
+
+		if (err == nil) != c.succeeds {
+			t.Errorf("%d failures with %d retries: unexpected result %v", c.failures, c.maxRetries, err)
+		}
+		if attempts != c.attempts || len(slept) != attempts-1 {
+			t.Errorf("%d failures with %d retries: expected %d attempts, got %d with %d sleeps", c.failures, c.maxRetries, c.attempts, attempts, len(slept))
+		}
+		db.Close()
+	}
+}
+
+func TestWithTransactionDoesNotRetryFatalErrors(t *testing.T) {
+	client := (&Config{maxRetries: 3}).Client()
+	client.sleep = func(context.Context, time.Duration) error {
+		t.Fatal("did not expect a retry")
+		return nil
+	}
+
+	db := sql.OpenDB(&fakeConnector{})
+	defer db.Close()
+
+	attempts := 0
+	err := client.withTransaction(context.Background(), db, "group analysts", func(tx *sql.Tx) error {
+		attempts++
+		return wrapError("grant", "group analysts", &pq.Error{Code: "42501", Message: "permission denied"})
+	})
+
+	if err == nil || attempts != 1 {
+		t.Fatalf("expected a single failed attempt, got %d: %v", attempts, err)
+	}
+}
+
+func TestWithTransactionStopsRetryingWhenCancelled(t *testing.T) {
+	client := (&Config{maxRetries: 3}).Client()
+	client.sleep = func(context.Context, time.Duration) error {
+		t.Fatal("did not expect a retry")
+		return nil
+	}
+
+	db := sql.OpenDB(&fakeConnector{})
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attempts := 0
+	err := client.withTransaction(ctx, db, "group analysts", func(tx *sql.Tx) error {
+		attempts++
+		cancel()
+		return wrapError("grant", "group analysts", &pq.Error{Code: "40001", Message: "could not serialize access"})
+	})
+
+	if err == nil || attempts != 1 {
+		t.Fatalf("expected a single failed attempt, got %d: %v", attempts, err)
+	}
+}
+
+func TestWithTransactionDoesNotRetryUnknownCommits(t *testing.T) {
+	cases := []struct {
+		commitErr error
+		attempts  int
+	}{
+		{&pq.Error{Code: "08006", Message: "connection failure"}, 1},
+		{driver.ErrBadConn, 1},
+		{&pq.Error{Code: "40001", Message: "could not serialize access"}, 4},
+	}
+
+	for _, c := range cases {
+		client := (&Config{maxRetries: 3}).Client()
+		client.sleep = func(context.Context, time.Duration) error { return nil }
+
+		db := sql.OpenDB(&fakeConnector{commitErr: c.commitErr})
+
+		attempts := 0
+		err := client.withTransaction(context.Background(), db, "group analysts", func(tx *sql.Tx) error {
+			attempts++
+			return nil
+		})
+
+		if err == nil || attempts != c.attempts {
+			t.Errorf("%s: expected %d failed attempts, got %d: %v", c.commitErr, c.attempts, attempts, err)
+		}
+		if unknown := errors.Is(err, errCommitUnknown); unknown != (c.attempts == 1) {
+			t.Errorf("%s: unexpected error %v", c.commitErr, err)
+		}
+		db.Close()
+		client.Close()
+	}
+}
+
+func TestWithRetryStopsWaitingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := sleepContext(ctx, time.Hour); err != context.Canceled {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("expected the wait to end when the context was cancelled, waited %s", elapsed)
+	}
+
+	client := (&Config{maxRetries: 3}).Client()
+	defer client.Close()
+	client.sleep = func(ctx context.Context, d time.Duration) error { return context.DeadlineExceeded }
+
+	serializationErr := &pq.Error{Code: "40001", Message: "could not serialize access"}
+	attempts := 0
+	err := client.withRetry(context.Background(), "group analysts", func() error {
+		attempts++
+		return serializationErr
+	})
+
+	if err != serializationErr || attempts != 1 {
+		t.Fatalf("expected a single failed attempt, got %d: %v", attempts, err)
+	}
+}
@@ -0,0 +1,132 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/frankfarrell/terraform-provider-redshift/internal/sqlbuilder"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// https://docs.aws.amazon.com/redshift/latest/dg/r_ALTER_USER.html
+// ALTER USER name SET parameter TO value sets the default of a configuration parameter for the
+// sessions of the user, and RESET parameter removes it. The defaults are stored in useconfig as
+// parameter=value.
+
+// sessionParameterName matches the parameter names written into statements as they are. They are
+// lower case, which is how useconfig stores them.
+var sessionParameterName = regexp.MustCompile(`^[a-z_][a-z0-9_.]*$`)
+
+// listSessionParameters take a comma separated list of values
+var listSessionParameters = map[string]bool{
+	"search_path": true,
+	"datestyle":   true,
+}
+
+// validateSessionParameters rejects parameter names that could change the shape of a statement
+func validateSessionParameters(v interface{}, k string) ([]string, []error) {
+	var errors []error
+	for name := range v.(map[string]interface{}) {
+		if !sessionParameterName.MatchString(name) {
+			errors = append(errors, fmt.Errorf("%s: %q is not a valid parameter name, expected lower case letters, digits, _ and .", k, name))
+		}
+	}
+	return nil, errors
+}
+
+// setSessionParameterStatement returns the statement setting the session default of name to value
+func setSessionParameterStatement(username string, name string, value string) string {
+
+	statement := sqlbuilder.New("ALTER USER").Ident(username).Keyword("SET", name, "TO")
+	if !listSessionParameters[name] {
+		return statement.Literal(value).String()
+	}
+
+	values := strings.Split(value, ",")
+	for i, element := range values {
+		values[i] = sqlbuilder.QuoteLiteral(strings.TrimSpace(element))
+	}
+	return statement.Keyword(strings.Join(values, ", ")).String()
+}
+
+// resetSessionParameterStatement returns the statement removing the session default of name
+func resetSessionParameterStatement(username string, name string) string {
+	return sqlbuilder.New("ALTER USER").Ident(username).Keyword("RESET", name).String()
+}
+
+// sessionParameterStatements returns the statements changing the session defaults of username from
+// old to new, in the order of the parameter names
+func sessionParameterStatements(username string, old map[string]interface{}, new map[string]interface{}) []string {
+
+	var names []string
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name, value := range new {
+		if oldValue, ok := old[name]; !ok || oldValue.(string) != value.(string) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var statements []string
+	for _, name := range names {
+		if value, ok := new[name]; ok {
+			statements = append(statements, setSessionParameterStatement(username, name, value.(string)))
+		} else {
+			statements = append(statements, resetSessionParameterStatement(username, name))
+		}
+	}
+	return statements
+}
+
+// normalizeSessionParameter returns value as the cluster stores it, without the double quotes and
+// spacing list elements can be stored with
+func normalizeSessionParameter(name string, value string) string {
+	if !listSessionParameters[name] {
+		return value
+	}
+	values := strings.Split(value, ",")
+	for i, element := range values {
+		values[i] = strings.Trim(strings.TrimSpace(element), `"`)
+	}
+	return strings.Join(values, ", ")
+}
+
+// readSessionParameters returns the session defaults in useconfig. Values equal to the configured
+// ones once normalized are returned as configured, so they show no difference.
+func readSessionParameters(configured map[string]interface{}, useconfig []string) map[string]interface{} {
+
+	parameters := make(map[string]interface{}, len(useconfig))
+	for _, entry := range useconfig {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := strings.ToLower(parts[0]), parts[1]
+
+		if c, ok := configured[name]; ok && normalizeSessionParameter(name, c.(string)) == normalizeSessionParameter(name, value) {
+			value = c.(string)
+		}
+		parameters[name] = value
+	}
+	return parameters
+}
+
+// updateSessionParameters applies the changes of session_parameters
+func updateSessionParameters(ctx context.Context, d *schema.ResourceData, tx *sql.Tx) error {
+
+	old, new := d.GetChange("session_parameters")
+	for _, statement := range sessionParameterStatements(d.Get("username").(string), old.(map[string]interface{}), new.(map[string]interface{})) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return wrapError("set session parameters of", userObject(d), err)
+		}
+	}
+	return nil
+}
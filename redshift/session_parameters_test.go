@@ -0,0 +1,87 @@
+package redshift
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestSessionParameterStatements(t *testing.T) {
+	old := map[string]interface{}{
+		"query_group":       "etl",
+		"statement_timeout": "60000",
+		"timezone":          "UTC",
+	}
+	new := map[string]interface{}{
+		"query_group":       "etl",
+		"search_path":       "$user, public",
+		"statement_timeout": "120000",
+	}
+
+	expected := []string{
+		`ALTER USER "service" SET search_path TO '$user', 'public'`,
+		`ALTER USER "service" SET statement_timeout TO '120000'`,
+		`ALTER USER "service" RESET timezone`,
+	}
+	if statements := sessionParameterStatements("service", old, new); !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected %q, got %q", expected, statements)
+	}
+
+	if statement := setSessionParameterStatement("service", "query_group", "it's, etl"); statement != `ALTER USER "service" SET query_group TO 'it''s, etl'` {
+		t.Errorf("expected a single escaped value, got %s", statement)
+	}
+}
+
+func TestValidateSessionParameters(t *testing.T) {
+	if _, errors := validateSessionParameters(map[string]interface{}{"search_path": "public", "enable_result_cache_for_session": "off"}, "session_parameters"); len(errors) != 0 {
+		t.Errorf("expected valid names, got %v", errors)
+	}
+	for _, name := range []string{"Search_Path", "timezone TO 'UTC'; DROP USER x; --", ""} {
+		if _, errors := validateSessionParameters(map[string]interface{}{name: "x"}, "session_parameters"); len(errors) == 0 {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestReadSessionParameters(t *testing.T) {
+	configured := map[string]interface{}{
+		"search_path": "$user,public",
+		"timezone":    "UTC",
+	}
+	useconfig := []string{`search_path="$user", public`, "TimeZone=Europe/Dublin", "datestyle=ISO, MDY", "malformed"}
+
+	expected := map[string]interface{}{
+		"search_path": "$user,public",
+		"timezone":    "Europe/Dublin",
+		"datestyle":   "ISO, MDY",
+	}
+	if parameters := readSessionParameters(configured, useconfig); !reflect.DeepEqual(parameters, expected) {
+		t.Errorf("expected %v, got %v", expected, parameters)
+	}
+}
+
+func TestReadRedshiftUserSessionParameters(t *testing.T) {
+	client := cannedClient(cannedUser("service", []byte(`{"search_path=\"$user\", public",statement_timeout=60000}`), false, "RESTRICTED", 0, ""))
+	defer client.Close()
+
+	d := schema.TestResourceDataRaw(t, redshiftUser().Schema, map[string]interface{}{
+		"username":           "service",
+		"session_parameters": map[string]interface{}{"search_path": "$user, public"},
+	})
+	d.SetId("100")
+
+	err := client.withTransaction(context.Background(), client.connections["dev"], userObject(d), func(tx *sql.Tx) error {
+		return readRedshiftUser(context.Background(), d, tx)
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{"search_path": "$user, public", "statement_timeout": "60000"}
+	if parameters := d.Get("session_parameters").(map[string]interface{}); !reflect.DeepEqual(parameters, expected) {
+		t.Errorf("expected %v, got %v", expected, parameters)
+	}
+}
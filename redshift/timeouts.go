@@ -0,0 +1,36 @@
+package redshift
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const defaultOperationTimeout = 5 * time.Minute
+
+// resourceTimeouts lets a timeouts block bound how long each operation may run, including
+// waiting for new objects to show up in the catalog
+func resourceTimeouts() *schema.ResourceTimeout {
+	return &schema.ResourceTimeout{
+		Create: schema.DefaultTimeout(defaultOperationTimeout),
+		Read:   schema.DefaultTimeout(defaultOperationTimeout),
+		Update: schema.DefaultTimeout(defaultOperationTimeout),
+		Delete: schema.DefaultTimeout(defaultOperationTimeout),
+	}
+}
+
+// operationContext returns the context an operation runs its SQL under, derived from the context
+// Terraform passed to it. That one is already cancelled when the timeout expires or Terraform
+// asks the provider to stop, eg on Ctrl-C, which makes the driver cancel any statement still
+// running on the server. The timeout is applied again for the operations that run as part of
+// another one, such as the read after a create. The operation and resource are recorded against
+// every statement in the audit log.
+func (c *Client) operationContext(ctx context.Context, d *schema.ResourceData, operation string, resource string) (context.Context, context.CancelFunc) {
+	ctx = withAuditTarget(ctx, auditTarget{operation: operation, resource: resource, id: d.Id()})
+	if plan := c.dryRunPlanFor(d); plan != nil {
+		plan.resource = resource
+		ctx = withDryRunPlan(ctx, plan)
+	}
+	return context.WithTimeout(ctx, d.Timeout(operation))
+}
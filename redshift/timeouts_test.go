@@ -0,0 +1,73 @@
+package redshift
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestOperationContextIsCancelledWhenProviderStops(t *testing.T) {
+	stopContext, stop := context.WithCancel(context.Background())
+
+	client := (&Config{}).Client()
+
+	d := schema.TestResourceDataRaw(t, redshiftGroup().Schema, map[string]interface{}{"group_name": "analysts"})
+
+	ctx, cancel := client.operationContext(stopContext, d, schema.TimeoutCreate, "group analysts")
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("expected the operation context to have a deadline")
+	}
+
+	stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the operation context to be cancelled")
+	}
+}
+
+func TestWaitForObjectGivesUpWhenContextIsDone(t *testing.T) {
+	client := fakeClient(&fakeConnector{})
+	defer client.Close()
+
+	db, _ := client.getConnection("dev")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var oid int
+	err := waitForObject(ctx, db, "SELECT oid FROM pg_namespace WHERE nspname = $1", []interface{}{"analytics"}, &oid)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+}
+
+func TestStoredIdentifier(t *testing.T) {
+	cases := []struct {
+		setting string
+		name    string
+		stored  string
+	}{
+		{"off", "Alice", "alice"},
+		{"off", "IAM:Data-Team", "iam:data-team"},
+		{"off", "Éric", "Éric"},
+		{"on", "Alice", "Alice"},
+	}
+
+	for _, c := range cases {
+		client := cannedClient(cannedResult{match: "enable_case_sensitive_identifier", columns: []string{"current_setting"}, rows: [][]driver.Value{{c.setting}}})
+		stored, err := storedIdentifier(context.Background(), client.connections["dev"], c.name)
+		client.Close()
+
+		if err != nil || stored != c.stored {
+			t.Errorf("%s with enable_case_sensitive_identifier %s: expected %s, got %s %v", c.name, c.setting, c.stored, stored, err)
+		}
+	}
+}
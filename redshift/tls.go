@@ -0,0 +1,140 @@
+package redshift
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// https://www.postgresql.org/docs/current/libpq-ssl.html
+// https://docs.aws.amazon.com/redshift/latest/mgmt/connecting-ssl-support.html
+
+// sslModes are the modes lib/pq supports. libpq's allow and prefer fall back to plain text
+// connections, which lib/pq does not do.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// tlsConfig holds the TLS settings of the provider. Each of the certificates and the key can be
+// a path or inline PEM content.
+type tlsConfig struct {
+	sslmode     string
+	sslrootcert string
+	sslcert     string
+	sslkey      string
+}
+
+// tlsFiles are the paths lib/pq reads the certificates and key from. Inline PEM content is
+// written to files in dir, which is removed when the client is closed.
+type tlsFiles struct {
+	dir         string
+	sslrootcert string
+	sslcert     string
+	sslkey      string
+}
+
+func isPEM(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN")
+}
+
+// readPEM returns value if it is inline PEM content, otherwise the content of the file it names
+func readPEM(attribute string, value string) ([]byte, error) {
+	if isPEM(value) {
+		return []byte(value), nil
+	}
+	content, err := ioutil.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %s", attribute, err)
+	}
+	return content, nil
+}
+
+// validate checks the certificates and key can be used, so misconfiguration is reported when
+// the provider is configured rather than on the first connection
+func (c *tlsConfig) validate() error {
+
+	if (c.sslcert == "") != (c.sslkey == "") {
+		return fmt.Errorf("sslcert and sslkey have to be set together")
+	}
+
+	if c.sslmode == "disable" && (c.sslrootcert != "" || c.sslcert != "") {
+		return fmt.Errorf("sslrootcert, sslcert and sslkey cannot be used with sslmode disable")
+	}
+
+	if c.sslrootcert != "" {
+		content, err := readPEM("sslrootcert", c.sslrootcert)
+		if err != nil {
+			return err
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(content) {
+			return fmt.Errorf("sslrootcert does not contain any PEM encoded certificates")
+		}
+	}
+
+	if c.sslcert != "" {
+		cert, err := readPEM("sslcert", c.sslcert)
+		if err != nil {
+			return err
+		}
+		key, err := readPEM("sslkey", c.sslkey)
+		if err != nil {
+			return err
+		}
+		if _, err := tls.X509KeyPair(cert, key); err != nil {
+			return fmt.Errorf("sslcert and sslkey are not a valid key pair: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// files returns the paths to hand to lib/pq, writing inline PEM content to a private directory
+func (c *tlsConfig) files() (*tlsFiles, error) {
+
+	files := &tlsFiles{}
+
+	materialize := func(value string, name string) (string, error) {
+		if value == "" || !isPEM(value) {
+			return value, nil
+		}
+		if files.dir == "" {
+			dir, err := ioutil.TempDir("", "terraform-provider-redshift")
+			if err != nil {
+				return "", fmt.Errorf("Could not create directory for TLS files: %s", err)
+			}
+			files.dir = dir
+		}
+		// lib/pq refuses keys that are readable by anyone but the owner
+		path := filepath.Join(files.dir, name)
+		if err := ioutil.WriteFile(path, []byte(value), 0600); err != nil {
+			return "", fmt.Errorf("Could not write %s: %s", name, err)
+		}
+		return path, nil
+	}
+
+	var err error
+	if files.sslrootcert, err = materialize(c.sslrootcert, "root.crt"); err != nil {
+		files.Close()
+		return nil, err
+	}
+	if files.sslcert, err = materialize(c.sslcert, "client.crt"); err != nil {
+		files.Close()
+		return nil, err
+	}
+	if files.sslkey, err = materialize(c.sslkey, "client.key"); err != nil {
+		files.Close()
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Close removes the files written for inline PEM content
+func (f *tlsFiles) Close() error {
+	if f == nil || f.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(f.dir)
+}
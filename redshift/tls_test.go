@@ -0,0 +1,282 @@
+package redshift
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     string
+	keyPEM      string
+}
+
+// issueCertificate signs a certificate for hosts with parent, or a self signed CA if parent is nil
+func issueCertificate(t *testing.T, parent *testCertificate, commonName string, hosts ...string) *testCertificate {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:      string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+// startTLSServer runs a server that speaks enough of the postgres protocol to negotiate TLS,
+// then refuses the startup message with "tls ok" so the client can tell the handshake passed.
+// When clientCA is set the server requires a client certificate signed by it.
+func startTLSServer(t *testing.T, server *testCertificate, clientCA *testCertificate) net.Listener {
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{server.certificate.Raw},
+			PrivateKey:  server.key,
+		}},
+	}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.certificate)
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				// SSLRequest: length 8 and the code 80877103
+				request := make([]byte, 8)
+				if _, err := io.ReadFull(conn, request); err != nil {
+					return
+				}
+				if _, err := conn.Write([]byte("S")); err != nil {
+					return
+				}
+
+				tlsConn := tls.Server(conn, config)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+
+				var length uint32
+				if err := binary.Read(tlsConn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				if _, err := io.CopyN(ioutil.Discard, tlsConn, int64(length)-4); err != nil {
+					return
+				}
+
+				fields := "SFATAL\x00C28000\x00Mtls ok\x00\x00"
+				response := []byte{'E', 0, 0, 0, 0}
+				binary.BigEndian.PutUint32(response[1:], uint32(4+len(fields)))
+				tlsConn.Write(append(response, fields...))
+				tlsConn.Close()
+			}()
+		}
+	}()
+
+	return listener
+}
+
+// connectTLS opens a connection to listener through host and returns the error, which is
+// "tls ok" when the TLS handshake succeeded
+func connectTLS(t *testing.T, listener net.Listener, host string, c tlsConfig) error {
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+	files, err := c.files()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer files.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	config := &Config{
+		url:         host,
+		port:        port,
+		sslmode:     c.sslmode,
+		tlsFiles:    files,
+		credentials: &staticCredentials{user: "terraform", password: "secret"},
+	}
+
+	conn, err := (&connector{config: config, database: "dev"}).Connect(context.Background())
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func TestTLSModes(t *testing.T) {
+	ca := issueCertificate(t, nil, "Test CA")
+	otherCA := issueCertificate(t, nil, "Other CA")
+	server := issueCertificate(t, ca, "redshift", "localhost")
+
+	listener := startTLSServer(t, server, nil)
+	defer listener.Close()
+
+	cases := []struct {
+		name     string
+		host     string
+		config   tlsConfig
+		expected string
+	}{
+		{"require ignores the CA", "localhost", tlsConfig{sslmode: "require"}, "tls ok"},
+		{"verify-ca", "localhost", tlsConfig{sslmode: "verify-ca", sslrootcert: ca.certPEM}, "tls ok"},
+		{"verify-ca with another CA", "localhost", tlsConfig{sslmode: "verify-ca", sslrootcert: otherCA.certPEM}, "certificate signed by unknown authority"},
+		{"verify-ca ignores the host name", "127.0.0.1", tlsConfig{sslmode: "verify-ca", sslrootcert: ca.certPEM}, "tls ok"},
+		{"verify-full", "localhost", tlsConfig{sslmode: "verify-full", sslrootcert: ca.certPEM}, "tls ok"},
+		{"verify-full with another host name", "127.0.0.1", tlsConfig{sslmode: "verify-full", sslrootcert: ca.certPEM}, "127.0.0.1"},
+	}
+
+	for _, tc := range cases {
+		err := connectTLS(t, listener, tc.host, tc.config)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.expected, err)
+		}
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	ca := issueCertificate(t, nil, "Test CA")
+	server := issueCertificate(t, ca, "redshift", "localhost")
+	client := issueCertificate(t, ca, "terraform")
+
+	listener := startTLSServer(t, server, ca)
+	defer listener.Close()
+
+	dir, err := ioutil.TempDir("", "redshift-tls-test")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certPath, []byte(client.certPEM), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ioutil.WriteFile(keyPath, []byte(client.keyPEM), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	inline := tlsConfig{sslmode: "verify-full", sslrootcert: ca.certPEM, sslcert: client.certPEM, sslkey: client.keyPEM}
+	if err := connectTLS(t, listener, "localhost", inline); err == nil || !strings.Contains(err.Error(), "tls ok") {
+		t.Errorf("inline client certificate: expected tls ok, got %v", err)
+	}
+
+	paths := tlsConfig{sslmode: "verify-full", sslrootcert: ca.certPEM, sslcert: certPath, sslkey: keyPath}
+	if err := connectTLS(t, listener, "localhost", paths); err == nil || !strings.Contains(err.Error(), "tls ok") {
+		t.Errorf("client certificate paths: expected tls ok, got %v", err)
+	}
+
+	without := tlsConfig{sslmode: "verify-full", sslrootcert: ca.certPEM}
+	if err := connectTLS(t, listener, "localhost", without); err == nil || strings.Contains(err.Error(), "tls ok") {
+		t.Errorf("expected the server to require a client certificate, got %v", err)
+	}
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	ca := issueCertificate(t, nil, "Test CA")
+	client := issueCertificate(t, ca, "terraform")
+	other := issueCertificate(t, ca, "other")
+
+	invalid := map[string]tlsConfig{
+		"certificate without key":  {sslmode: "require", sslcert: client.certPEM},
+		"certificates and disable": {sslmode: "disable", sslrootcert: ca.certPEM},
+		"mismatched key":           {sslmode: "require", sslcert: client.certPEM, sslkey: other.keyPEM},
+		"missing root certificate": {sslmode: "verify-full", sslrootcert: "/does/not/exist.crt"},
+		"no certificates in root":  {sslmode: "verify-full", sslrootcert: "-----BEGIN nothing"},
+	}
+	for name, c := range invalid {
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	files, err := (&tlsConfig{sslmode: "require", sslcert: client.certPEM, sslkey: client.keyPEM}).files()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	info, err := os.Stat(files.sslkey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		t.Errorf("expected the key to be private, got %v", info.Mode())
+	}
+	files.Close()
+	if _, err := os.Stat(files.dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", files.dir)
+	}
+}
@@ -0,0 +1,220 @@
+package redshift
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// sshTunnelConfig holds the settings of the ssh_tunnel block
+type sshTunnelConfig struct {
+	host                 string
+	port                 int
+	user                 string
+	privateKey           string
+	privateKeyPassphrase string
+	knownHostsFile       string
+	hostKey              string
+}
+
+// sshTunnel dials database connections through a bastion host. One SSH connection is shared by
+// every pool of the client and reopened if it drops.
+type sshTunnel struct {
+	mutex   sync.Mutex
+	address string
+	config  *ssh.ClientConfig
+	client  *ssh.Client
+
+	// forward opens the connection to the bastion, through a proxy if one is configured
+	forward proxy.Dialer
+}
+
+func newSSHTunnel(c sshTunnelConfig) (*sshTunnel, error) {
+
+	auth, err := sshAuthMethod(c)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshTunnel{
+		address: net.JoinHostPort(c.host, strconv.Itoa(c.port)),
+		config: &ssh.ClientConfig{
+			User:            c.user,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		forward: proxy.Direct,
+	}, nil
+}
+
+// sshAuthMethod authenticates with the configured private key, falling back to a running ssh-agent
+func sshAuthMethod(c sshTunnelConfig) (ssh.AuthMethod, error) {
+
+	if c.privateKey != "" {
+		var (
+			signer ssh.Signer
+			err    error
+		)
+		if c.privateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(c.privateKey), []byte(c.privateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(c.privateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse ssh_tunnel private_key: %s", err)
+		}
+		return ssh.PublicKeys(signer), nil
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("ssh_tunnel needs a private_key or a running ssh-agent (SSH_AUTH_SOCK)")
+	}
+
+	// The agent is asked for its keys on every handshake, so keys added later are picked up
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("Could not connect to ssh-agent: %s", err)
+		}
+		defer conn.Close()
+		return agent.NewClient(conn).Signers()
+	}), nil
+}
+
+// sshHostKeyCallback verifies the bastion against host_key when it is set, otherwise against
+// the known_hosts file
+func sshHostKeyCallback(c sshTunnelConfig) (ssh.HostKeyCallback, error) {
+
+	if c.hostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.hostKey))
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse ssh_tunnel host_key: %s", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	}
+
+	knownHostsFile := c.knownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("Could not find the default known_hosts file: %s", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read ssh_tunnel known_hosts_file: %s", err)
+	}
+	return callback, nil
+}
+
+// connect returns the shared SSH connection, opening it if needed
+func (t *sshTunnel) connect() (*ssh.Client, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	log.Printf("Opening ssh tunnel through %s", t.address)
+
+	conn, err := dialWithTimeout(func() (net.Conn, error) {
+		return t.forward.Dial("tcp", t.address)
+	}, t.address, t.config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open ssh tunnel through %s: %s", t.address, err)
+	}
+
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, t.address, t.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not open ssh tunnel through %s: %s", t.address, err)
+	}
+
+	client := ssh.NewClient(sshConn, channels, requests)
+	t.client = client
+	return client, nil
+}
+
+// reset drops client so the next dial opens a new SSH connection
+func (t *sshTunnel) reset(client *ssh.Client) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.client == client {
+		t.client.Close()
+		t.client = nil
+	}
+}
+
+// Dial opens a connection to address as seen from the bastion
+func (t *sshTunnel) Dial(network, address string) (net.Conn, error) {
+
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.Dial(network, address)
+	if err == nil {
+		return conn, nil
+	}
+
+	// The SSH connection may have been dropped by the bastion, so try once more on a new one
+	log.Printf("Could not dial %s through ssh tunnel, reconnecting: %s", address, err)
+	t.reset(client)
+
+	client, err = t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err = client.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("Could not dial %s through ssh tunnel: %s", address, err)
+	}
+	return conn, nil
+}
+
+// DialTimeout is Dial giving up after timeout
+func (t *sshTunnel) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return dialWithTimeout(func() (net.Conn, error) {
+		return t.Dial(network, address)
+	}, address, timeout)
+}
+
+// Close closes the SSH connection
+func (t *sshTunnel) Close() error {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.client == nil {
+		return nil
+	}
+
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
@@ -0,0 +1,194 @@
+package redshift
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func generateSigner(t *testing.T) (ssh.Signer, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return signer, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+// startBastion runs an SSH server that accepts clientKey and forwards direct-tcpip channels
+func startBastion(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) net.Listener {
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+						newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, channelRequests, err := newChannel.Accept()
+					if err != nil {
+						upstream.Close()
+						continue
+					}
+					go ssh.DiscardRequests(channelRequests)
+					go func() {
+						io.Copy(channel, upstream)
+						channel.Close()
+					}()
+					go func() {
+						io.Copy(upstream, channel)
+						upstream.Close()
+					}()
+				}
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestSSHTunnelDialsThroughBastion(t *testing.T) {
+	hostKey, _ := generateSigner(t)
+	clientKey, clientPEM := generateSigner(t)
+
+	bastion := startBastion(t, hostKey, clientKey.PublicKey())
+	defer bastion.Close()
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	host, port, _ := net.SplitHostPort(bastion.Addr().String())
+	bastionPort, _ := strconv.Atoi(port)
+
+	tunnel, err := newSSHTunnel(sshTunnelConfig{
+		host:       host,
+		port:       bastionPort,
+		user:       "terraform",
+		privateKey: clientPEM,
+		hostKey:    string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())),
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer tunnel.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := tunnel.Dial("tcp", echo.Addr().String())
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+			t.Fatalf("expected ping to be echoed, got %q: %v", reply, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestSSHTunnelRejectsUnknownHostKey(t *testing.T) {
+	hostKey, _ := generateSigner(t)
+	otherKey, _ := generateSigner(t)
+	clientKey, clientPEM := generateSigner(t)
+
+	bastion := startBastion(t, hostKey, clientKey.PublicKey())
+	defer bastion.Close()
+
+	host, port, _ := net.SplitHostPort(bastion.Addr().String())
+	bastionPort, _ := strconv.Atoi(port)
+
+	tunnel, err := newSSHTunnel(sshTunnelConfig{
+		host:       host,
+		port:       bastionPort,
+		user:       "terraform",
+		privateKey: clientPEM,
+		hostKey:    string(ssh.MarshalAuthorizedKey(otherKey.PublicKey())),
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer tunnel.Close()
+
+	if _, err := tunnel.Dial("tcp", "127.0.0.1:5439"); err == nil {
+		t.Fatal("expected the bastion to be rejected")
+	}
+}
+
+func TestSSHTunnelNeedsCredentials(t *testing.T) {
+	if _, err := sshAuthMethod(sshTunnelConfig{privateKey: "not a key"}); err == nil {
+		t.Error("expected an invalid private key to be rejected")
+	}
+}
@@ -1,21 +1,35 @@
 package redshift
 
 import (
-	"github.com/hashicorp/terraform/helper/schema"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
-// errorString is a trivial implementation of error.
-type errorString struct {
-	s string
-}
+// validateConnectionLimit accepts UNLIMITED or a non negative number. The value is written into
+// statements as is, so anything else is rejected at plan time.
+func validateConnectionLimit(v interface{}, k string) ([]string, []error) {
+	value := v.(string)
 
-func (e *errorString) Error() string {
-	return e.s
+	if value == "UNLIMITED" {
+		return nil, nil
+	}
+	if limit, err := strconv.Atoi(value); err != nil || limit < 0 {
+		return nil, []error{fmt.Errorf("%s must be UNLIMITED or a non negative number, got %q", k, value)}
+	}
+	return nil, nil
 }
 
-// NewError returns an error that formats as the given text.
-func NewError(text string) error {
-	return &errorString{text}
+// validateSessionTimeout accepts 0, which leaves the cluster timeout, or the 60 seconds to 20 days
+// ALTER USER ... SESSION TIMEOUT accepts
+func validateSessionTimeout(v interface{}, k string) ([]string, []error) {
+	value := v.(int)
+
+	if value != 0 && (value < 60 || value > 1728000) {
+		return nil, []error{fmt.Errorf("%s must be 0 or between 60 and 1728000 seconds, got %d", k, value)}
+	}
+	return nil, nil
 }
 
 func isSystemSchema(schemaOwner int) bool {